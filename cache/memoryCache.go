@@ -1,39 +1,269 @@
 package cache
 
 import (
+	"hash/fnv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/danielglennross/go-dcb/schema"
 )
 
-// MemoryCache default memory cache
+// MemoryCache default memory cache. Circuits are striped over shards, each
+// with its own locks, and copied on the way in & out so callers can't
+// change a stored circuit outside a lock
 type MemoryCache struct {
-	lookup map[string]*schema.Circuit
-	mutex  sync.Mutex
+	shards   []*memoryShard
+	idleMs   int
+	sweepMs  int
+	exit     chan bool
+	once     sync.Once
+	sweeping sync.Once
+}
+
+type memoryShard struct {
+	mutex    sync.RWMutex // guards lookup
+	lookup   map[string]*memoryEntry
+	critical sync.Mutex // held by RunCritical
+}
+
+type memoryEntry struct {
+	circuit   *schema.Circuit
+	expiresAt time.Time // zero if it doesn't expire
+	touched   int64     // unix nanoseconds of the last read or write, atomic
+}
+
+func (entry *memoryEntry) expired(now time.Time) bool {
+	return !entry.expiresAt.IsZero() && !now.Before(entry.expiresAt)
+}
+
+// MemoryCacheOption memory cache option
+type MemoryCacheOption func(*MemoryCache)
+
+// Stripes number of shards circuits are spread over (default 64)
+func Stripes(n int) MemoryCacheOption {
+	return func(cache *MemoryCache) {
+		if n < 1 {
+			n = 1
+		}
+		cache.shards = make([]*memoryShard, n)
+	}
+}
+
+// EvictIdleMs evict circuits which haven't been read or written for idleMs,
+// checked in the background until Close (0 disables). Isolated, force closed
+// & disabled circuits are never idle, as evicting them would close them
+func EvictIdleMs(idleMs int) MemoryCacheOption {
+	return func(cache *MemoryCache) {
+		cache.idleMs = idleMs
+	}
+}
+
+// SweepExpiredMs how often expired circuits are dropped (default 1000), in
+// the background from the first Expire (or with EvictIdleMs) until Close
+func SweepExpiredMs(sweepMs int) MemoryCacheOption {
+	return func(cache *MemoryCache) {
+		cache.sweepMs = sweepMs
+	}
 }
 
 // NewMemoryCache ctor
-func NewMemoryCache() *MemoryCache {
+func NewMemoryCache(options ...MemoryCacheOption) *MemoryCache {
 	cache := new(MemoryCache)
-	cache.lookup = make(map[string]*schema.Circuit)
+	cache.shards = make([]*memoryShard, 64)
+	cache.exit = make(chan bool)
+	cache.sweepMs = 1000
+
+	for _, opt := range options {
+		opt(cache)
+	}
+
+	for i := range cache.shards {
+		cache.shards[i] = &memoryShard{lookup: make(map[string]*memoryEntry)}
+	}
+
+	if cache.idleMs > 0 {
+		cache.sweep()
+	}
+
 	return cache
 }
 
-// Get gets item from cache
+// Close stops evicting idle & expired circuits
+func (cache *MemoryCache) Close() {
+	cache.once.Do(func() { close(cache.exit) })
+}
+
+func (cache *MemoryCache) shard(ID string) *memoryShard {
+	h := fnv.New32a()
+	h.Write([]byte(ID))
+	return cache.shards[h.Sum32()%uint32(len(cache.shards))]
+}
+
+// Get gets a copy of an item from cache
 func (cache *MemoryCache) Get(ID string) (*schema.Circuit, error) {
-	circuit := cache.lookup[ID]
-	return circuit, nil
+	circuit, _, err := cache.GetVersioned(ID)
+	return circuit, err
 }
 
-// Set sets item in cache
+// Set sets a copy of an item in cache, clearing any expiry
 func (cache *MemoryCache) Set(ID string, circuit *schema.Circuit) error {
-	cache.lookup[ID] = circuit
+	shard := cache.shard(ID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	var version int64
+	if prev := shard.live(ID, time.Now()); prev != nil {
+		version = prev.circuit.Version
+	}
+	shard.store(ID, circuit, version+1)
+	return nil
+}
+
+// GetVersioned gets a copy of an item & its version from cache
+func (cache *MemoryCache) GetVersioned(ID string) (*schema.Circuit, int64, error) {
+	shard := cache.shard(ID)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	entry := shard.live(ID, time.Now())
+	if entry == nil {
+		return nil, 0, nil
+	}
+	atomic.StoreInt64(&entry.touched, time.Now().UnixNano())
+	return entry.circuit.Clone(), entry.circuit.Version, nil
+}
+
+// CompareAndSet sets a copy of an item in cache if its version is unchanged
+func (cache *MemoryCache) CompareAndSet(ID string, circuit *schema.Circuit, expectedVersion int64) (bool, error) {
+	shard := cache.shard(ID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	var version int64
+	if prev := shard.live(ID, time.Now()); prev != nil {
+		version = prev.circuit.Version
+	}
+	if version != expectedVersion {
+		return false, nil
+	}
+
+	shard.store(ID, circuit, expectedVersion+1)
+	return true, nil
+}
+
+// List lists the IDs of circuits starting with prefix
+func (cache *MemoryCache) List(prefix string) ([]string, error) {
+	now := time.Now()
+	IDs := []string{}
+
+	for _, shard := range cache.shards {
+		shard.mutex.RLock()
+		for ID, entry := range shard.lookup {
+			if strings.HasPrefix(ID, prefix) && !entry.expired(now) {
+				IDs = append(IDs, ID)
+			}
+		}
+		shard.mutex.RUnlock()
+	}
+	return IDs, nil
+}
+
+// Delete deletes item from cache
+func (cache *MemoryCache) Delete(ID string) error {
+	shard := cache.shard(ID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	delete(shard.lookup, ID)
+	return nil
+}
+
+// Expire expires item after ttl (<= 0 never), until it's next set
+func (cache *MemoryCache) Expire(ID string, ttl time.Duration) error {
+	shard := cache.shard(ID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	entry := shard.live(ID, time.Now())
+	if entry == nil {
+		return nil
+	}
+
+	entry.expiresAt = time.Time{}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+		cache.sweep()
+	}
 	return nil
 }
 
-// RunCritical run critical section
+// RunCritical run critical section, exclusive of any other on the same ID's shard
 func (cache *MemoryCache) RunCritical(ID string, fn func() (interface{}, error)) (interface{}, error) {
-	cache.mutex.Lock()
-	defer cache.mutex.Unlock()
+	shard := cache.shard(ID)
+	shard.critical.Lock()
+	defer shard.critical.Unlock()
 	return fn()
 }
+
+// sweep starts evicting in the background, if it hasn't already
+func (cache *MemoryCache) sweep() {
+	cache.sweeping.Do(func() {
+		go cache.evict(time.Millisecond*time.Duration(cache.idleMs), time.Millisecond*time.Duration(cache.sweepMs))
+	})
+}
+
+// evict drops expired circuits every interval, & idle circuits (if idle > 0)
+// every half idle period
+func (cache *MemoryCache) evict(idle, interval time.Duration) {
+	if idle > 0 && (interval <= 0 || idle/2 < interval) {
+		interval = idle / 2
+	}
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cache.exit:
+			return
+		case now := <-ticker.C:
+			idleSince := now.Add(-idle).UnixNano()
+
+			for _, shard := range cache.shards {
+				shard.mutex.Lock()
+				for ID, entry := range shard.lookup {
+					if entry.expired(now) || (idle > 0 && !pinned(entry.circuit.State) && atomic.LoadInt64(&entry.touched) < idleSince) {
+						delete(shard.lookup, ID)
+					}
+				}
+				shard.mutex.Unlock()
+			}
+		}
+	}
+}
+
+// pinned states set by hand, which idle eviction mustn't reset to closed
+func pinned(state schema.State) bool {
+	return state == schema.Isolate || state == schema.ForceClosed || state == schema.Disabled
+}
+
+// live an unexpired entry, the shard's mutex must be held
+func (shard *memoryShard) live(ID string, now time.Time) *memoryEntry {
+	entry := shard.lookup[ID]
+	if entry == nil || entry.expired(now) {
+		return nil
+	}
+	return entry
+}
+
+// store stores a copy of circuit, the shard's mutex must be held for writing
+func (shard *memoryShard) store(ID string, circuit *schema.Circuit, version int64) {
+	stored := circuit.Clone()
+	stored.Version = version
+	shard.lookup[ID] = &memoryEntry{circuit: stored, touched: time.Now().UnixNano()}
+}
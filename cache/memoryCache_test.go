@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danielglennross/go-dcb/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryCacheCopiesOnReadAndWrite(t *testing.T) {
+	cache := NewMemoryCache()
+
+	circuit := &schema.Circuit{State: schema.Closed}
+	require.NoError(t, cache.Set("id", circuit))
+	circuit.State = schema.Open
+
+	got, err := cache.Get("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Closed, got.State)
+
+	got.State = schema.Isolate
+
+	got, err = cache.Get("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Closed, got.State)
+}
+
+func TestMemoryCacheRunCriticalAcrossManyIDs(t *testing.T) {
+	cache := NewMemoryCache(Stripes(8))
+
+	const ids, writers, increments = 100, 8, 20
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		for i := 0; i < ids; i++ {
+			wg.Add(1)
+			go func(ID string) {
+				defer wg.Done()
+
+				for n := 0; n < increments; n++ {
+					_, err := cache.RunCritical(ID, func() (interface{}, error) {
+						circuit, err := cache.Get(ID)
+						if err != nil {
+							return nil, err
+						}
+						if circuit == nil {
+							circuit = &schema.Circuit{}
+						}
+						circuit.Failures++
+						return nil, cache.Set(ID, circuit)
+					})
+					require.NoError(t, err)
+				}
+			}(fmt.Sprintf("id-%d", i))
+		}
+	}
+	wg.Wait()
+
+	for i := 0; i < ids; i++ {
+		circuit, err := cache.Get(fmt.Sprintf("id-%d", i))
+		require.NoError(t, err)
+		require.Equal(t, writers*increments, circuit.Failures)
+		require.Equal(t, int64(writers*increments), circuit.Version)
+	}
+}
+
+func TestMemoryCacheCompareAndSetAcrossManyIDs(t *testing.T) {
+	cache := NewMemoryCache()
+
+	const ids, writers, increments = 100, 8, 20
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		for i := 0; i < ids; i++ {
+			wg.Add(1)
+			go func(ID string) {
+				defer wg.Done()
+
+				for n := 0; n < increments; {
+					circuit, version, err := cache.GetVersioned(ID)
+					require.NoError(t, err)
+					if circuit == nil {
+						circuit = &schema.Circuit{}
+					}
+					circuit.Failures++
+
+					ok, err := cache.CompareAndSet(ID, circuit, version)
+					require.NoError(t, err)
+					if ok {
+						n++
+					}
+				}
+			}(fmt.Sprintf("id-%d", i))
+		}
+	}
+	wg.Wait()
+
+	for i := 0; i < ids; i++ {
+		circuit, err := cache.Get(fmt.Sprintf("id-%d", i))
+		require.NoError(t, err)
+		require.Equal(t, writers*increments, circuit.Failures)
+	}
+}
+
+func TestMemoryCacheEvictsIdleCircuits(t *testing.T) {
+	cache := NewMemoryCache(EvictIdleMs(20))
+	defer cache.Close()
+
+	require.NoError(t, cache.Set("idle", &schema.Circuit{}))
+	require.NoError(t, cache.Set("busy", &schema.Circuit{}))
+
+	for i := 0; i < 10; i++ {
+		time.Sleep(5 * time.Millisecond)
+		_, err := cache.Get("busy")
+		require.NoError(t, err)
+	}
+
+	IDs, err := cache.List("")
+	require.NoError(t, err)
+	require.Equal(t, []string{"busy"}, IDs)
+}
+
+func TestMemoryCacheNeverEvictsPinnedCircuits(t *testing.T) {
+	cache := NewMemoryCache(EvictIdleMs(10))
+	defer cache.Close()
+
+	states := map[string]schema.State{
+		"closed":       schema.Closed,
+		"isolate":      schema.Isolate,
+		"force-closed": schema.ForceClosed,
+		"disabled":     schema.Disabled,
+	}
+	for ID, state := range states {
+		require.NoError(t, cache.Set(ID, &schema.Circuit{State: state}))
+	}
+	time.Sleep(40 * time.Millisecond)
+
+	IDs, err := cache.List("")
+	require.NoError(t, err)
+	sort.Strings(IDs)
+	require.Equal(t, []string{"disabled", "force-closed", "isolate"}, IDs)
+}
+
+func TestMemoryCacheSweepsExpiredWithoutIdleEviction(t *testing.T) {
+	cache := NewMemoryCache(SweepExpiredMs(5))
+	defer cache.Close()
+
+	require.NoError(t, cache.Set("id", &schema.Circuit{State: schema.Isolate}))
+	require.NoError(t, cache.Expire("id", 10*time.Millisecond))
+	time.Sleep(40 * time.Millisecond)
+
+	shard := cache.shard("id")
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+	require.NotContains(t, shard.lookup, "id")
+}
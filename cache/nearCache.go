@@ -0,0 +1,188 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/danielglennross/go-dcb/schema"
+)
+
+// NearCache serves reads from a local copy of a remote cache's circuits, for
+// up to StalenessMs, and writes through to the remote cache.
+// Writes are compared & set against the remote version, so a stale local copy
+// is never written back; a conflict drops it. With a DistLock, the breaker
+// reads the remote copy (GetLatest) inside the lock
+type NearCache struct {
+	remote      schema.VersionedCache
+	stalenessMs int
+	maxCopies   int
+	mutex       sync.RWMutex
+	local       map[string]nearEntry
+}
+
+type nearEntry struct {
+	circuit   *schema.Circuit
+	version   int64
+	fetchedAt time.Time
+}
+
+// NearCacheOption near cache option
+type NearCacheOption func(*NearCache)
+
+// StalenessMs how long a local copy is served before it's read again from the remote cache
+func StalenessMs(ms int) NearCacheOption {
+	return func(nc *NearCache) {
+		nc.stalenessMs = ms
+	}
+}
+
+// MaxLocalCopies how many circuits are copied locally (default 10000, < 1
+// unbounded), past which stale copies, then arbitrary ones, are dropped
+func MaxLocalCopies(n int) NearCacheOption {
+	return func(nc *NearCache) {
+		nc.maxCopies = n
+	}
+}
+
+// NewNearCache ctor
+func NewNearCache(remote schema.VersionedCache, options ...NearCacheOption) *NearCache {
+	nc := new(NearCache)
+	nc.remote = remote
+	nc.local = make(map[string]nearEntry)
+
+	nc.stalenessMs = 1000
+	nc.maxCopies = 10000
+
+	for _, opt := range options {
+		opt(nc)
+	}
+
+	return nc
+}
+
+// Get gets a copy of an item, locally if fresh enough
+func (nc *NearCache) Get(ID string) (*schema.Circuit, error) {
+	circuit, _, err := nc.GetVersioned(ID)
+	return circuit, err
+}
+
+// Set sets item in the remote cache, dropping the local copy
+func (nc *NearCache) Set(ID string, circuit *schema.Circuit) error {
+	defer nc.Invalidate(ID)
+	return nc.remote.Set(ID, circuit)
+}
+
+// GetVersioned gets a copy of an item & its version, locally if fresh enough
+func (nc *NearCache) GetVersioned(ID string) (*schema.Circuit, int64, error) {
+	nc.mutex.RLock()
+	entry, ok := nc.local[ID]
+	nc.mutex.RUnlock()
+
+	if ok && nc.fresh(entry, time.Now()) {
+		return entry.circuit.Clone(), entry.version, nil
+	}
+	return nc.fetch(ID)
+}
+
+// GetLatest gets a copy of an item from the remote cache, refreshing the local copy
+func (nc *NearCache) GetLatest(ID string) (*schema.Circuit, error) {
+	circuit, _, err := nc.fetch(ID)
+	return circuit, err
+}
+
+func (nc *NearCache) fetch(ID string) (*schema.Circuit, int64, error) {
+	circuit, version, err := nc.remote.GetVersioned(ID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	nc.store(ID, nearEntry{circuit.Clone(), version, time.Now()})
+	return circuit.Clone(), version, nil
+}
+
+func (nc *NearCache) fresh(entry nearEntry, now time.Time) bool {
+	return now.Sub(entry.fetchedAt) < time.Millisecond*time.Duration(nc.stalenessMs)
+}
+
+// CompareAndSet sets item in the remote cache if its version is unchanged,
+// keeping a local copy if so & dropping it if not
+func (nc *NearCache) CompareAndSet(ID string, circuit *schema.Circuit, expectedVersion int64) (bool, error) {
+	ok, err := nc.remote.CompareAndSet(ID, circuit, expectedVersion)
+	if err != nil || !ok {
+		nc.Invalidate(ID)
+		return ok, err
+	}
+
+	stored := circuit.Clone()
+	stored.Version = expectedVersion + 1
+	nc.store(ID, nearEntry{stored, stored.Version, time.Now()})
+	return true, nil
+}
+
+// List lists the IDs of circuits in the remote cache starting with prefix
+func (nc *NearCache) List(prefix string) ([]string, error) {
+	mc, err := nc.managed()
+	if err != nil {
+		return nil, err
+	}
+	return mc.List(prefix)
+}
+
+// Delete deletes item from the remote cache, dropping the local copy
+func (nc *NearCache) Delete(ID string) error {
+	mc, err := nc.managed()
+	if err != nil {
+		return err
+	}
+	defer nc.Invalidate(ID)
+	return mc.Delete(ID)
+}
+
+// Expire expires item in the remote cache, dropping the local copy
+func (nc *NearCache) Expire(ID string, ttl time.Duration) error {
+	mc, err := nc.managed()
+	if err != nil {
+		return err
+	}
+	defer nc.Invalidate(ID)
+	return mc.Expire(ID, ttl)
+}
+
+func (nc *NearCache) managed() (schema.ManagedCache, error) {
+	mc, ok := nc.remote.(schema.ManagedCache)
+	if !ok {
+		return nil, fmt.Errorf("Remote cache %T can't list, delete or expire circuits", nc.remote)
+	}
+	return mc, nil
+}
+
+// Invalidate drops the local copy of an item, so it's next read from the remote cache
+func (nc *NearCache) Invalidate(ID string) {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	delete(nc.local, ID)
+}
+
+// store keeps a local copy, dropping others if there are already maxCopies
+func (nc *NearCache) store(ID string, entry nearEntry) {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	if _, ok := nc.local[ID]; !ok && nc.maxCopies > 0 && len(nc.local) >= nc.maxCopies {
+		for key, copied := range nc.local {
+			if !nc.fresh(copied, entry.fetchedAt) {
+				delete(nc.local, key)
+			}
+		}
+		for key := range nc.local {
+			if len(nc.local) < nc.maxCopies {
+				break
+			}
+			delete(nc.local, key)
+		}
+	}
+
+	nc.local[ID] = entry
+}
@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danielglennross/go-dcb/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNearCacheServesLocalCopyWithinStaleness(t *testing.T) {
+	remote := NewMemoryCache()
+	require.NoError(t, remote.Set("id", &schema.Circuit{State: schema.Closed}))
+
+	nc := NewNearCache(remote, StalenessMs(50))
+
+	circuit, err := nc.Get("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Closed, circuit.State)
+
+	require.NoError(t, remote.Set("id", &schema.Circuit{State: schema.Open}))
+
+	circuit, err = nc.Get("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Closed, circuit.State)
+
+	time.Sleep(60 * time.Millisecond)
+
+	circuit, err = nc.Get("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Open, circuit.State)
+}
+
+func TestNearCacheDropsLocalCopyOnConflict(t *testing.T) {
+	remote := NewMemoryCache()
+	require.NoError(t, remote.Set("id", &schema.Circuit{State: schema.Closed}))
+
+	nc := NewNearCache(remote, StalenessMs(60000))
+
+	circuit, version, err := nc.GetVersioned("id")
+	require.NoError(t, err)
+
+	// another node's write
+	require.NoError(t, remote.Set("id", &schema.Circuit{State: schema.Isolate}))
+
+	circuit.State = schema.Open
+	ok, err := nc.CompareAndSet("id", circuit, version)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	circuit, _, err = nc.GetVersioned("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Isolate, circuit.State)
+}
+
+func TestNearCacheInvalidate(t *testing.T) {
+	remote := NewMemoryCache()
+	require.NoError(t, remote.Set("id", &schema.Circuit{State: schema.Closed}))
+
+	nc := NewNearCache(remote, StalenessMs(60000))
+
+	_, err := nc.Get("id")
+	require.NoError(t, err)
+
+	require.NoError(t, remote.Set("id", &schema.Circuit{State: schema.Open}))
+	nc.Invalidate("id")
+
+	circuit, err := nc.Get("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Open, circuit.State)
+}
+
+func TestNearCacheGetLatestReadsRemote(t *testing.T) {
+	remote := NewMemoryCache()
+	require.NoError(t, remote.Set("id", &schema.Circuit{State: schema.Closed}))
+
+	nc := NewNearCache(remote, StalenessMs(60000))
+	_, err := nc.Get("id")
+	require.NoError(t, err)
+
+	require.NoError(t, remote.Set("id", &schema.Circuit{State: schema.Isolate}))
+
+	circuit, err := nc.GetLatest("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Isolate, circuit.State)
+
+	circuit, err = nc.Get("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Isolate, circuit.State)
+}
+
+func TestNearCacheBoundsLocalCopies(t *testing.T) {
+	remote := NewMemoryCache()
+	nc := NewNearCache(remote, StalenessMs(60000), MaxLocalCopies(2))
+
+	for _, ID := range []string{"a", "b", "c", "d"} {
+		_, err := nc.Get(ID)
+		require.NoError(t, err)
+		require.True(t, len(nc.local) <= 2)
+	}
+	require.Contains(t, nc.local, "d")
+}
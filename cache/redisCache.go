@@ -1,9 +1,13 @@
+// Package cache provides circuit caches and distributed locks.
 package cache
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,13 +16,91 @@ import (
 )
 
 const (
-	lockScript   = `return redis.call("set", KEYS[1], ARGV[1], "NX", "PX", ARGV[2])`
+	// lockScript a fence counter which expired starts again from the clock (in
+	// milliseconds), so it's still past every fence it handed out before. A
+	// legacy lock key (KEYS[3]) is taken alongside the lock's
+	lockScript = `
+	redis.replicate_commands()
+	if KEYS[3] and redis.call("exists", KEYS[3]) == 1 then
+		return 0
+	end
+	if redis.call("set", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+		if KEYS[3] then
+			redis.call("set", KEYS[3], ARGV[1], "PX", ARGV[2])
+		end
+		local fence = redis.call("incr", KEYS[2])
+		local now = redis.call("time")
+		local floor = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+		if fence < floor then
+			fence = floor
+			redis.call("set", KEYS[2], fence)
+		end
+		if tonumber(ARGV[3]) > 0 then
+			redis.call("pexpire", KEYS[2], ARGV[3])
+		end
+		return fence
+	end
+	return 0
+	`
+	raiseFenceScript = `
+	local fence = tonumber(redis.call("get", KEYS[1]) or "0")
+	if fence < tonumber(ARGV[1]) then
+		redis.call("set", KEYS[1], ARGV[1])
+		if tonumber(ARGV[2]) > 0 then
+			redis.call("pexpire", KEYS[1], ARGV[2])
+		end
+	end
+	return 1
+	`
 	unlockScript = `
-	if redis.call("get", KEYS[1]) == ARGV[1] then
-		return redis.call("del", KEYS[1])
+	local deleted = 0
+	for _, key in ipairs(KEYS) do
+		if redis.call("get", key) == ARGV[1] then
+			deleted = deleted + redis.call("del", key)
+		end
+	end
+	return deleted
+	`
+	extendScript = `
+	for _, key in ipairs(KEYS) do
+		if redis.call("get", key) ~= ARGV[1] then
+			return 0
+		end
+	end
+	for _, key in ipairs(KEYS) do
+		redis.call("pexpire", key, ARGV[2])
+	end
+	return 1
+	`
+	compareAndSetVersionScript = `
+	local version = 0
+	local current = redis.call("get", KEYS[1])
+	if current then
+		version = cjson.decode(current).Version or 0
+	end
+	if version ~= tonumber(ARGV[1]) then
+		return 0
+	end
+	if tonumber(ARGV[3]) > 0 then
+		redis.call("set", KEYS[1], ARGV[2], "PX", ARGV[3])
 	else
+		redis.call("set", KEYS[1], ARGV[2])
+	end
+	return 1
+	`
+	setFencedScript = `
+	local accepted = tonumber(redis.call("get", KEYS[2]) or "0")
+	if tonumber(ARGV[1]) < accepted then
 		return 0
 	end
+	if tonumber(ARGV[3]) > 0 then
+		redis.call("set", KEYS[2], ARGV[1], "PX", ARGV[3])
+		redis.call("set", KEYS[1], ARGV[2], "PX", ARGV[3])
+	else
+		redis.call("set", KEYS[2], ARGV[1])
+		redis.call("set", KEYS[1], ARGV[2])
+	end
+	return 1
 	`
 )
 
@@ -27,7 +109,10 @@ type RedisCache struct {
 	logError schema.Log
 	logInfo  schema.Log
 	ttl      int
-	client   *redis.Client
+	keys     keyspace
+	legacy   bool
+	client   redis.UniversalClient
+	owned    bool
 }
 
 // RedLock redis lock
@@ -36,93 +121,217 @@ type RedLock struct {
 	retryDelayMs int
 	driftFactor  float64
 	ttlMs        int
+	watchdogMs   int
+	fenceTTLms   int
+	keys         keyspace
+	legacy       bool
 	logError     schema.Log
 	logInfo      schema.Log
-	clients      []*redis.Client
+	clients      []redis.UniversalClient
+	owned        bool
 }
 
-type redLockOption func(*RedLock)
+// RedLockOption red lock option
+type RedLockOption func(*RedLock)
 
-// ClientOption redis client option
+// ClientOption redis client option. Address a single node; Addrs cluster
+// nodes, or sentinels with MasterName
 type ClientOption struct {
-	Address  string
-	Password string
-	DB       int
+	Address    string
+	Addrs      []string
+	MasterName string
+	Password   string
+	DB         int
+}
+
+// newClient creates a client, Sentinel backed if MasterName is set,
+// Cluster if several Addrs are set, otherwise single node
+func newClient(co ClientOption) redis.UniversalClient {
+	addrs := co.Addrs
+	if co.Address != "" {
+		addrs = append([]string{co.Address}, addrs...)
+	}
+	return redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs:      addrs,
+		MasterName: co.MasterName,
+		Password:   co.Password,
+		DB:         co.DB,
+	})
+}
+
+// keyspace prefix of a cache's (or lock's) keys. A circuit's ID is hash
+// tagged, so every key of a circuit lands in the same cluster slot
+type keyspace string
+
+func (ks keyspace) circuit(ID string) string {
+	return fmt.Sprintf("%s{%s}", ks, tag(ID))
+}
+
+func (ks keyspace) lock(ID string) string {
+	return fmt.Sprintf("%s{%s}-lock", ks, tag(ID))
+}
+
+func (ks keyspace) fence(ID string) string {
+	return fmt.Sprintf("%s{%s}-fence", ks, tag(ID))
+}
+
+func (ks keyspace) acceptedFence(ID string) string {
+	return fmt.Sprintf("%s{%s}-fence-accepted", ks, tag(ID))
+}
+
+var (
+	tagEscaper   = strings.NewReplacer(`\`, `\\`, "{", `\(`, "}", `\)`)
+	tagUnescaper = strings.NewReplacer(`\\`, `\`, `\(`, "{", `\)`, "}", `\0`, "")
+)
+
+// tag a circuit's hash tag: its ID with braces (& backslashes) escaped, so
+// the tag spans the whole ID, & an empty ID as \0, as an empty tag isn't one
+func tag(ID string) string {
+	if ID == "" {
+		return `\0`
+	}
+	return tagEscaper.Replace(ID)
+}
+
+// untag the ID of a hash tag
+func untag(tag string) string {
+	return tagUnescaper.Replace(tag)
+}
+
+// legacyLock the lock key used before keys were hash tagged (or prefixed)
+func legacyLock(ID string) string {
+	return fmt.Sprintf("%s-lock", ID)
 }
 
 // RetryCount rery count
-func RetryCount(r int) redLockOption {
+func RetryCount(r int) RedLockOption {
 	return func(rc *RedLock) {
 		rc.retryCount = r
 	}
 }
 
 // RetryDelayMs in milliseconds
-func RetryDelayMs(r int) redLockOption {
+func RetryDelayMs(r int) RedLockOption {
 	return func(rc *RedLock) {
 		rc.retryDelayMs = r
 	}
 }
 
 // DriftFactor drift factor
-func DriftFactor(df float64) redLockOption {
+func DriftFactor(df float64) RedLockOption {
 	return func(rc *RedLock) {
 		rc.driftFactor = df
 	}
 }
 
 // TTLms to live in milliseconds
-func TTLms(ttlMs int) redLockOption {
+func TTLms(ttlMs int) RedLockOption {
 	return func(rc *RedLock) {
 		rc.ttlMs = ttlMs
 	}
 }
 
+// Watchdog renew the lease every watchdogMs while RunCritical's fn runs (0 disables),
+// it's held under half of TTLms so a renewal lands before the lease expires
+func Watchdog(watchdogMs int) RedLockOption {
+	return func(rc *RedLock) {
+		rc.watchdogMs = watchdogMs
+	}
+}
+
+// FenceTTLms how long a circuit's fence counter is kept after its last lock
+// (0 never). A counter which expired starts again from the clock, so it only
+// needs to outlive the clock skew between instances
+func FenceTTLms(fenceTTLms int) RedLockOption {
+	return func(rc *RedLock) {
+		rc.fenceTTLms = fenceTTLms
+	}
+}
+
+// LegacyLockKeys also take each circuit's lock key from before keys were hash
+// tagged (ID-lock), so the lock excludes nodes still running an older version
+// during a rolling deploy. Only for single node or Sentinel clients, as the two
+// keys may be in different cluster slots
+func LegacyLockKeys() RedLockOption {
+	return func(rc *RedLock) {
+		rc.legacy = true
+	}
+}
+
+// LockKeyPrefix prefix of the lock's keys, to namespace them in a shared database
+// (for example "payments:"), it mustn't contain braces
+func LockKeyPrefix(prefix string) RedLockOption {
+	return func(rc *RedLock) {
+		rc.keys = keyspace(prefix)
+	}
+}
+
 // RedLockLogError log error delegate
-func RedLockLogError(le schema.Log) redLockOption {
+func RedLockLogError(le schema.Log) RedLockOption {
 	return func(rc *RedLock) {
 		rc.logError = le
 	}
 }
 
 // RedLockLogInfo log info delegate
-func RedLockLogInfo(li schema.Log) redLockOption {
+func RedLockLogInfo(li schema.Log) RedLockOption {
 	return func(rc *RedLock) {
 		rc.logInfo = li
 	}
 }
 
-type redisCacheOption func(*RedisCache)
+// RedisCacheOption redis cache option
+type RedisCacheOption func(*RedisCache)
 
 // TTL time to live in milliseconds
-func TTL(ttl int) redisCacheOption {
+func TTL(ttl int) RedisCacheOption {
 	return func(rc *RedisCache) {
 		rc.ttl = ttl
 	}
 }
 
+// KeyPrefix prefix of the cache's keys, to namespace them in a shared database
+// (for example "payments:"), it mustn't contain braces
+func KeyPrefix(prefix string) RedisCacheOption {
+	return func(rc *RedisCache) {
+		rc.keys = keyspace(prefix)
+	}
+}
+
+// LegacyKeys read a circuit from its key from before keys were hash tagged
+// (its bare ID) when it's not under its own, carrying it over on upgrade.
+// RedisCache only, a RedisStore ignores it
+func LegacyKeys() RedisCacheOption {
+	return func(rc *RedisCache) {
+		rc.legacy = true
+	}
+}
+
 // CacheLogError log error delegate
-func CacheLogError(le schema.Log) redisCacheOption {
+func CacheLogError(le schema.Log) RedisCacheOption {
 	return func(rc *RedisCache) {
 		rc.logError = le
 	}
 }
 
 // CacheLogInfo log info delegate
-func CacheLogInfo(li schema.Log) redisCacheOption {
+func CacheLogInfo(li schema.Log) RedisCacheOption {
 	return func(rc *RedisCache) {
 		rc.logInfo = li
 	}
 }
 
 // NewRedisCache ctor
-func NewRedisCache(client ClientOption, options ...redisCacheOption) *RedisCache {
+func NewRedisCache(client ClientOption, options ...RedisCacheOption) *RedisCache {
+	cache := NewRedisCacheFromClient(newClient(client), options...)
+	cache.owned = true
+	return cache
+}
+
+// NewRedisCacheFromClient ctor, using a Client, ClusterClient, FailoverClient or Ring
+func NewRedisCacheFromClient(client redis.UniversalClient, options ...RedisCacheOption) *RedisCache {
 	cache := new(RedisCache)
-	cache.client = redis.NewClient(&redis.Options{
-		Addr:     client.Address,
-		Password: client.Password,
-		DB:       client.DB,
-	})
+	cache.client = client
 
 	cache.ttl = 500
 	cache.logError = func(message string, context interface{}) {}
@@ -136,24 +345,26 @@ func NewRedisCache(client ClientOption, options ...redisCacheOption) *RedisCache
 }
 
 // NewRedLock create new red lock
-func NewRedLock(clients []ClientOption, options ...redLockOption) *RedLock {
-	rl := new(RedLock)
-
-	cls := []*redis.Client{}
+func NewRedLock(clients []ClientOption, options ...RedLockOption) *RedLock {
+	cls := []redis.UniversalClient{}
 	for _, co := range clients {
-		c := redis.NewClient(&redis.Options{
-			Addr:     co.Address,
-			Password: co.Password,
-			DB:       co.DB,
-		})
-		cls = append(cls, c)
+		cls = append(cls, newClient(co))
 	}
-	rl.clients = cls
+	rl := NewRedLockFromClients(cls, options...)
+	rl.owned = true
+	return rl
+}
+
+// NewRedLockFromClients create new red lock, each client an independent instance of the quorum
+func NewRedLockFromClients(clients []redis.UniversalClient, options ...RedLockOption) *RedLock {
+	rl := new(RedLock)
+	rl.clients = clients
 
 	rl.retryDelayMs = 300
-	rl.retryDelayMs = 3
+	rl.retryCount = 3
 	rl.driftFactor = 0.01
 	rl.ttlMs = 500
+	rl.fenceTTLms = 60000
 	rl.logError = func(message string, context interface{}) {}
 	rl.logInfo = func(message string, context interface{}) {}
 
@@ -161,12 +372,43 @@ func NewRedLock(clients []ClientOption, options ...redLockOption) *RedLock {
 		opt(rl)
 	}
 
+	if limit := rl.ttlMs / 2; rl.watchdogMs > 0 && rl.watchdogMs >= limit {
+		rl.logError(fmt.Sprintf("Watchdog %dms must be under half of TTLms %dms", rl.watchdogMs, rl.ttlMs), nil)
+		rl.watchdogMs = limit - 1
+		if rl.watchdogMs < 1 {
+			rl.watchdogMs = 1
+		}
+	}
+
 	return rl
 }
 
+// Close closes the cache's client, if the cache created it
+func (cache *RedisCache) Close() error {
+	if !cache.owned {
+		return nil
+	}
+	return cache.client.Close()
+}
+
+// Close closes the lock's clients, if the lock created them
+func (rl *RedLock) Close() error {
+	if !rl.owned {
+		return nil
+	}
+
+	var err error
+	for _, client := range rl.clients {
+		if closeErr := client.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
 // Get gets item from cache
 func (cache *RedisCache) Get(ID string) (*schema.Circuit, error) {
-	val, err := cache.client.Get(ID).Result()
+	val, legacy, err := cache.read(ID)
 	if err == redis.Nil {
 		return nil, nil
 	}
@@ -175,45 +417,328 @@ func (cache *RedisCache) Get(ID string) (*schema.Circuit, error) {
 	}
 
 	res := &schema.Circuit{}
-	json.Unmarshal([]byte(val), res)
+	if err := json.Unmarshal([]byte(val), res); err != nil {
+		return nil, fmt.Errorf("Could not read circuit for ID %s: %w", ID, err)
+	}
+	if legacy {
+		// nothing's under its own key yet
+		res.Version = 0
+	}
+	if err := schema.Migrate(res); err != nil {
+		return nil, fmt.Errorf("Could not read circuit for ID %s: %w", ID, err)
+	}
 	return res, nil
 }
 
+// read reads a circuit, from its legacy key with LegacyKeys if it's not under
+// its own (legacy reports which)
+func (cache *RedisCache) read(ID string) (string, bool, error) {
+	val, err := cache.client.Get(cache.keys.circuit(ID)).Result()
+	if err != redis.Nil || !cache.legacy {
+		return val, false, err
+	}
+	val, err = cache.client.Get(ID).Result()
+	return val, true, err
+}
+
 // Set sets item in cache
 func (cache *RedisCache) Set(ID string, circuit *schema.Circuit) error {
-	cir, err := json.Marshal(circuit)
+	stored := *circuit
+	stored.Version++
+	stored.SchemaVersion = schema.CurrentSchemaVersion
+
+	cir, err := json.Marshal(stored)
 	if err != nil {
 		return err
 	}
 	ttl := time.Millisecond * time.Duration(cache.ttl)
-	return cache.client.Set(ID, cir, ttl).Err()
+	return cache.client.Set(cache.keys.circuit(ID), cir, ttl).Err()
+}
+
+// clusterScanner & ringScanner clients whose keys are spread over several nodes
+type clusterScanner interface {
+	ForEachMaster(fn func(client *redis.Client) error) error
+}
+
+type ringScanner interface {
+	ForEachShard(fn func(client *redis.Client) error) error
 }
 
-// RunCritical run critical section
+// List lists the IDs of circuits starting with prefix, scanning every node
+func (cache *RedisCache) List(prefix string) ([]string, error) {
+	var mutex sync.Mutex
+	IDs := []string{}
+
+	match := fmt.Sprintf("%s{%s*}", globEscape(string(cache.keys)), globEscape(tagEscaper.Replace(prefix)))
+	start := len(cache.keys) + 1 // past the prefix & "{"
+
+	scan := func(client redis.Cmdable) error {
+		it := client.Scan(0, match, 100).Iterator()
+		for it.Next() {
+			key := it.Val()
+
+			mutex.Lock()
+			IDs = append(IDs, untag(key[start:len(key)-1]))
+			mutex.Unlock()
+		}
+		return it.Err()
+	}
+	each := func(client *redis.Client) error {
+		return scan(client)
+	}
+
+	var err error
+	switch c := cache.client.(type) {
+	case clusterScanner:
+		err = c.ForEachMaster(each)
+	case ringScanner:
+		err = c.ForEachShard(each)
+	default:
+		err = scan(c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return IDs, nil
+}
+
+// Delete deletes item (& the fence last accepted for it) from cache
+func (cache *RedisCache) Delete(ID string) error {
+	return cache.client.Del(cache.keys.circuit(ID), cache.keys.acceptedFence(ID)).Err()
+}
+
+// Expire expires item (& the fence last accepted for it) after ttl (<= 0 never), until it's next set
+func (cache *RedisCache) Expire(ID string, ttl time.Duration) error {
+	for _, key := range []string{cache.keys.circuit(ID), cache.keys.acceptedFence(ID)} {
+		var err error
+		if ttl <= 0 {
+			err = cache.client.Persist(key).Err()
+		} else {
+			err = cache.client.PExpire(key, ttl).Err()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// globEscape escapes a key's part for a SCAN match pattern
+func globEscape(part string) string {
+	var b strings.Builder
+	for _, r := range part {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// SetFenced sets item in cache, unless a write with a newer fencing token was already accepted
+func (cache *RedisCache) SetFenced(ID string, circuit *schema.Circuit, fence int64) error {
+	stored := *circuit
+	stored.Version++
+	stored.SchemaVersion = schema.CurrentSchemaVersion
+
+	cir, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{cache.keys.circuit(ID), cache.keys.acceptedFence(ID)}
+	res, err := cache.client.Eval(setFencedScript, keys, fence, cir, cache.ttl).Result()
+	if err != nil {
+		return err
+	}
+	if res.(int64) == 0 {
+		return fmt.Errorf("%w %d for %s", schema.ErrStaleFence, fence, ID)
+	}
+	return nil
+}
+
+// GetVersioned gets item & its version from cache
+func (cache *RedisCache) GetVersioned(ID string) (*schema.Circuit, int64, error) {
+	circuit, err := cache.Get(ID)
+	if err != nil || circuit == nil {
+		return nil, 0, err
+	}
+	return circuit, circuit.Version, nil
+}
+
+// CompareAndSet sets item in cache if its version is unchanged
+func (cache *RedisCache) CompareAndSet(ID string, circuit *schema.Circuit, expectedVersion int64) (bool, error) {
+	stored := *circuit
+	stored.Version = expectedVersion + 1
+	stored.SchemaVersion = schema.CurrentSchemaVersion
+
+	cir, err := json.Marshal(stored)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := cache.client.Eval(compareAndSetVersionScript, []string{cache.keys.circuit(ID)}, expectedVersion, cir, cache.ttl).Result()
+	if err != nil {
+		return false, err
+	}
+	return res.(int64) == 1, nil
+}
+
+// Lease a held lock
+type Lease struct {
+	ID string
+	// Fence fencing token, greater than that of any earlier acquisition
+	Fence int64
+	keys  []string
+	token string
+}
+
+// Lock acquires the lock for ID
+func (rl *RedLock) Lock(ID string) (*Lease, error) {
+	keys := []string{rl.keys.lock(ID)}
+	if rl.legacy {
+		keys = append(keys, legacyLock(ID))
+	}
+
+	token, fence, err := rl.lock(keys, rl.keys.fence(ID))
+	if err != nil {
+		return nil, err
+	}
+	return &Lease{ID: ID, Fence: fence, keys: keys, token: token}, nil
+}
+
+// Unlock releases a lease
+func (rl *RedLock) Unlock(lease *Lease) {
+	rl.unlock(lease.keys, lease.token)
+}
+
+// Extend renews a lease for another TTLms, if it's still held by a quorum
+func (rl *RedLock) Extend(lease *Lease) error {
+	extendInstance := func(client redis.UniversalClient, c chan bool) {
+		res, err := client.Eval(extendScript, lease.keys, lease.token, strconv.Itoa(rl.ttlMs)).Result()
+		c <- err == nil && res.(int64) == 1
+	}
+
+	success := 0
+	c := make(chan bool, len(rl.clients))
+	for _, client := range rl.clients {
+		go extendInstance(client, c)
+	}
+	for j := 0; j < len(rl.clients); j++ {
+		if <-c {
+			success++
+		}
+	}
+	close(c)
+
+	quorum := (len(rl.clients) / 2) + 1
+	if success < quorum {
+		return fmt.Errorf("%w for %s", schema.ErrLockLost, lease.ID)
+	}
+	return nil
+}
+
+// RunCritical run critical section, fn is not run if the lock can't be acquired.
+// With a Watchdog, the lease is renewed while fn runs, if a renewal fails
+// fn's result is returned with an ErrLockLost error
 func (rl *RedLock) RunCritical(ID string, fn func() (interface{}, error)) (interface{}, error) {
-	rl.lock(fmt.Sprintf("%s-lock", ID))
-	defer rl.unlock(fmt.Sprintf("%s-lock", ID))
-	return fn()
+	return rl.RunCriticalFenced(ID, func(fence int64) (interface{}, error) {
+		return fn()
+	})
+}
+
+// RunCriticalFenced as RunCritical, handing fn the lease's fencing token
+func (rl *RedLock) RunCriticalFenced(ID string, fn func(fence int64) (interface{}, error)) (interface{}, error) {
+	lease, err := rl.Lock(ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rl.Unlock(lease)
+
+	if rl.watchdogMs < 1 {
+		return fn(lease.Fence)
+	}
+
+	stop := make(chan struct{})
+	lost := make(chan error, 1)
+	go rl.watch(lease, stop, lost)
+
+	res, err := fn(lease.Fence)
+	close(stop)
+
+	if lostErr := <-lost; lostErr != nil && err == nil {
+		return res, lostErr
+	}
+	return res, err
 }
 
-func (rl *RedLock) lock(ID string) error {
-	lockInstance := func(client *redis.Client, ID string, ttl int, c chan bool) {
-		_, err := client.Eval(lockScript, []string{ID}, ID, strconv.Itoa(ttl)).Result()
-		c <- err == nil
+// watch renews a lease until stopped, reporting the first failed renewal
+func (rl *RedLock) watch(lease *Lease, stop chan struct{}, lost chan error) {
+	ticker := time.NewTicker(time.Duration(rl.watchdogMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			lost <- nil
+			return
+		case <-ticker.C:
+			if err := rl.Extend(lease); err != nil {
+				rl.logError(fmt.Sprintf("Could not extend lock for ID %s", lease.ID), err)
+				lost <- err
+				return
+			}
+		}
+	}
+}
+
+// newToken random value identifying a single lock acquisition
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// lock acquires keys on a quorum, incrementing fenceKey on each instance
+// they're acquired on. The greatest fence is raised on every instance, so any
+// later quorum sees it
+func (rl *RedLock) lock(keys []string, fenceKey string) (string, int64, error) {
+	scriptKeys := append([]string{keys[0], fenceKey}, keys[1:]...)
+
+	type locked struct {
+		ok    bool
+		fence int64
+	}
+
+	lockInstance := func(client redis.UniversalClient, token string, ttl int, c chan locked) {
+		res, err := client.Eval(lockScript, scriptKeys, token, strconv.Itoa(ttl), rl.fenceTTLms).Result()
+		fence, _ := res.(int64)
+		c <- locked{err == nil && fence > 0, fence}
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return "", 0, err
 	}
 
 	for i := 0; i < rl.retryCount; i++ {
 		ttlMs := rl.ttlMs
 		success := 0
+		var fence int64
 		startNs := time.Now().UnixNano()
 
-		c := make(chan bool, len(rl.clients))
+		c := make(chan locked, len(rl.clients))
 		for _, client := range rl.clients {
-			go lockInstance(client, ID, ttlMs, c)
+			go lockInstance(client, token, ttlMs, c)
 		}
 		for j := 0; j < len(rl.clients); j++ {
-			if <-c {
+			if l := <-c; l.ok {
 				success++
+				if l.fence > fence {
+					fence = l.fence
+				}
 			}
 		}
 		close(c)
@@ -224,30 +749,52 @@ func (rl *RedLock) lock(ID string) error {
 
 		quorum := (len(rl.clients) / 2) + 1
 		if success >= quorum && validityTime > 0 {
-			return nil
+			rl.raiseFence(fenceKey, fence)
+			return token, fence, nil
 		}
 
-		rl.unlock(ID)
+		rl.unlock(keys, token)
 		time.Sleep(time.Duration(rl.retryDelayMs) * time.Millisecond)
 	}
 
-	return fmt.Errorf("Failed to lock for %s", ID)
+	return "", 0, fmt.Errorf("%w for %s", schema.ErrLockNotAcquired, keys[0])
 }
 
-func (rl *RedLock) unlock(ID string) {
+func (rl *RedLock) raiseFence(fenceKey string, fence int64) {
 	var wg sync.WaitGroup
 
-	unlockInstance := func(client *redis.Client, ID string) {
+	raiseInstance := func(client redis.UniversalClient) {
 		defer wg.Done()
 
-		_, err := client.Eval(unlockScript, []string{ID}, ID).Result()
+		_, err := client.Eval(raiseFenceScript, []string{fenceKey}, fence, rl.fenceTTLms).Result()
+		if err != nil {
+			rl.logError(fmt.Sprintf("Could not raise fence %s", fenceKey), err)
+		}
+	}
 
-		rl.logError(fmt.Sprintf("Could not unlock ID %s", ID), err)
+	wg.Add(len(rl.clients))
+	for _, client := range rl.clients {
+		go raiseInstance(client)
+	}
+
+	wg.Wait()
+}
+
+func (rl *RedLock) unlock(keys []string, token string) {
+	var wg sync.WaitGroup
+
+	unlockInstance := func(client redis.UniversalClient) {
+		defer wg.Done()
+
+		_, err := client.Eval(unlockScript, keys, token).Result()
+		if err != nil {
+			rl.logError(fmt.Sprintf("Could not unlock ID %s", keys[0]), err)
+		}
 	}
 
 	wg.Add(len(rl.clients))
 	for _, client := range rl.clients {
-		go unlockInstance(client, ID)
+		go unlockInstance(client)
 	}
 
 	wg.Wait()
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/danielglennross/go-dcb/schema"
+	"github.com/go-redis/redis"
+)
+
+// RedisNotifier publishes circuit transitions over a Redis pub/sub channel
+type RedisNotifier struct {
+	logError schema.Log
+	channel  string
+	client   redis.UniversalClient
+	owned    bool
+}
+
+// RedisNotifierOption redis notifier option
+type RedisNotifierOption func(*RedisNotifier)
+
+// subscriber a client supporting pub/sub (Client, ClusterClient & Ring do)
+type subscriber interface {
+	Subscribe(channels ...string) *redis.PubSub
+}
+
+// Channel pub/sub channel to publish on
+func Channel(channel string) RedisNotifierOption {
+	return func(rn *RedisNotifier) {
+		rn.channel = channel
+	}
+}
+
+// NotifierLogError log error delegate
+func NotifierLogError(le schema.Log) RedisNotifierOption {
+	return func(rn *RedisNotifier) {
+		rn.logError = le
+	}
+}
+
+// NewRedisNotifier ctor
+func NewRedisNotifier(client ClientOption, options ...RedisNotifierOption) *RedisNotifier {
+	rn := NewRedisNotifierFromClient(newClient(client), options...)
+	rn.owned = true
+	return rn
+}
+
+// Close closes the notifier's client, if the notifier created it
+func (rn *RedisNotifier) Close() error {
+	if !rn.owned {
+		return nil
+	}
+	return rn.client.Close()
+}
+
+// NewRedisNotifierFromClient ctor, using a Client, ClusterClient, FailoverClient or Ring
+func NewRedisNotifierFromClient(client redis.UniversalClient, options ...RedisNotifierOption) *RedisNotifier {
+	rn := new(RedisNotifier)
+	rn.client = client
+
+	rn.channel = "dcb-transitions"
+	rn.logError = func(message string, context interface{}) {}
+
+	for _, opt := range options {
+		opt(rn)
+	}
+
+	return rn
+}
+
+// Publish publishes a transition to every subscriber
+func (rn *RedisNotifier) Publish(notification schema.Notification) error {
+	msg, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	// Publish isn't part of UniversalClient, though every client can process it
+	cmd := redis.NewIntCmd("publish", rn.channel, string(msg))
+	if err := rn.client.Process(cmd); err != nil {
+		return err
+	}
+	return cmd.Err()
+}
+
+// Subscribe calls fn with every transition published, until unsubscribed
+func (rn *RedisNotifier) Subscribe(fn func(notification schema.Notification)) (func() error, error) {
+	sub, ok := rn.client.(subscriber)
+	if !ok {
+		return nil, fmt.Errorf("Redis client %T does not support pub/sub", rn.client)
+	}
+
+	pubsub := sub.Subscribe(rn.channel)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for msg := range pubsub.Channel() {
+			var notification schema.Notification
+			if err := json.Unmarshal([]byte(msg.Payload), &notification); err != nil {
+				rn.logError(fmt.Sprintf("Could not read notification on %s", rn.channel), err)
+				continue
+			}
+			fn(notification)
+		}
+	}()
+
+	return func() error {
+		err := pubsub.Close()
+		<-done
+		return err
+	}, nil
+}
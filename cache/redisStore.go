@@ -0,0 +1,527 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/danielglennross/go-dcb/schema"
+	"github.com/go-redis/redis"
+)
+
+// circuitScript shared by the transition scripts, mirrors the breaker's
+// transitions (see state.go & window.go in package dcb)
+const circuitScript = `
+local CLOSED, OPEN, HALF_OPEN, ISOLATE, FORCE_CLOSED, DISABLED = 0, 1, 2, 3, 4, 5
+local NO_WINDOW, COUNT_WINDOW = 0, 1
+local ADMITTED, ADMITTED_PROBE, REJECTED_OPEN, REJECTED_PROBE_LIMIT = 0, 1, 2, 3
+
+local function closed()
+	-- SchemaVersion mirrors schema.CurrentSchemaVersion
+	return {SchemaVersion = 1, State = CLOSED, Failures = 0, OpenedAt = 0, Window = {Seq = 0}, Probes = 0, ProbeSuccesses = 0, ProbesExpireAt = 0}
+end
+
+local function load(key)
+	local raw = redis.call("get", key)
+	if not raw then
+		return nil
+	end
+	return cjson.decode(raw)
+end
+
+-- set sets a key, expiring after ttl milliseconds (<= 0 never)
+local function set(key, value, ttl)
+	if tonumber(ttl) > 0 then
+		redis.call("set", key, value, "PX", ttl)
+	else
+		redis.call("set", key, value)
+	end
+end
+
+local function save(key, c, ttl)
+	if c.Window.Buckets ~= nil and #c.Window.Buckets == 0 then
+		c.Window.Buckets = nil
+	end
+	set(key, cjson.encode(c), ttl)
+end
+
+local function window_buckets(p)
+	if p.Window == COUNT_WINDOW then
+		return p.WindowSize
+	end
+	return p.WindowBuckets
+end
+
+local function window_head(p, w, now)
+	if p.Window == COUNT_WINDOW then
+		return w.Seq - 1
+	end
+	local span = math.floor(p.WindowSizeMs / p.WindowBuckets)
+	if span < 1 then
+		span = 1
+	end
+	return math.floor(now / span)
+end
+
+local function record(p, w, failed, slow, now)
+	if p.Window == NO_WINDOW then
+		return
+	end
+
+	local size = window_buckets(p)
+	if w.Buckets == nil or #w.Buckets ~= size then
+		w.Seq = 0
+		w.Buckets = {}
+		for i = 1, size do
+			w.Buckets[i] = {Epoch = 0, Calls = 0, Failures = 0, SlowCalls = 0}
+		end
+	end
+
+	if p.Window == COUNT_WINDOW then
+		w.Seq = w.Seq + 1
+	end
+
+	local epoch = window_head(p, w, now)
+	local b = w.Buckets[(epoch % size) + 1]
+	if b.Epoch ~= epoch or b.Calls == 0 then
+		b.Epoch, b.Calls, b.Failures, b.SlowCalls = epoch, 0, 0, 0
+	end
+
+	b.Calls = b.Calls + 1
+	if failed then
+		b.Failures = b.Failures + 1
+	end
+	if slow then
+		b.SlowCalls = b.SlowCalls + 1
+	end
+end
+
+local function should_trip(p, c, now)
+	if p.Window == NO_WINDOW then
+		return c.Failures > p.Threshold
+	end
+
+	local w = c.Window
+	if w.Buckets == nil then
+		return false
+	end
+
+	local size = window_buckets(p)
+	local head = window_head(p, w, now)
+	local calls, failures, slow = 0, 0, 0
+	for _, b in ipairs(w.Buckets) do
+		if b.Calls > 0 and b.Epoch <= head and b.Epoch > head - size then
+			calls = calls + b.Calls
+			failures = failures + b.Failures
+			slow = slow + b.SlowCalls
+		end
+	end
+
+	if calls == 0 or calls < p.MinimumCalls then
+		return false
+	end
+	if failures * 100 / calls >= p.FailureRateThreshold then
+		return true
+	end
+	return p.SlowCallDurationMs > 0 and slow * 100 / calls >= p.SlowCallRateThreshold
+end
+
+local function reset_probes(c)
+	c.Probes, c.ProbeSuccesses, c.ProbesExpireAt = 0, 0, 0
+end
+
+local function open_circuit(c, now)
+	c.State, c.OpenedAt = OPEN, now
+	reset_probes(c)
+end
+
+local function close_circuit(c)
+	c.State, c.OpenedAt, c.Failures, c.Window = CLOSED, 0, 0, {Seq = 0}
+	reset_probes(c)
+end
+`
+
+var checkStateScript = redis.NewScript(circuitScript + `
+local raw = redis.call("get", KEYS[1])
+if raw then
+	return raw
+end
+
+local c = closed()
+save(KEYS[1], c, ARGV[1])
+return cjson.encode(c)
+`)
+
+var tryHalfOpenScript = redis.NewScript(circuitScript + `
+local p, now, ttl = cjson.decode(ARGV[1]), tonumber(ARGV[2]), ARGV[3]
+
+local c = load(KEYS[1])
+if c == nil or c.State == CLOSED or c.State == FORCE_CLOSED or c.State == DISABLED then
+	return {ADMITTED, -1, -1}
+end
+if c.State == ISOLATE then
+	return {REJECTED_OPEN, -1, -1}
+end
+
+local from, to = -1, -1
+if c.State == OPEN then
+	if now - c.OpenedAt <= p.GracePeriodMs then
+		return {REJECTED_OPEN, -1, -1}
+	end
+	c.State = HALF_OPEN
+	reset_probes(c)
+	from, to = OPEN, HALF_OPEN
+end
+
+if p.HalfOpenMaxProbes < 1 then
+	if from ~= -1 then
+		save(KEYS[1], c, ttl)
+	end
+	return {ADMITTED, from, to, cjson.encode(c)}
+end
+
+if c.Probes >= p.HalfOpenMaxProbes then
+	if now < c.ProbesExpireAt then
+		if from ~= -1 then
+			save(KEYS[1], c, ttl)
+		end
+		return {REJECTED_PROBE_LIMIT, from, to, cjson.encode(c)}
+	end
+	c.Probes = 0
+end
+
+c.Probes = c.Probes + 1
+c.ProbesExpireAt = now + p.ProbeLeaseMs
+save(KEYS[1], c, ttl)
+return {ADMITTED_PROBE, from, to, cjson.encode(c)}
+`)
+
+var recordFailureScript = redis.NewScript(circuitScript + `
+local p, now, ttl, out = cjson.decode(ARGV[1]), tonumber(ARGV[2]), ARGV[3], cjson.decode(ARGV[4])
+
+local c = load(KEYS[1]) or closed()
+if c.State == OPEN or c.State == ISOLATE or c.State == FORCE_CLOSED then
+	return {-1, -1}
+end
+
+local from = c.State
+c.Failures = c.Failures + 1
+record(p, c.Window, true, out.Slow, now)
+
+if c.State == DISABLED then
+	save(KEYS[1], c, ttl)
+	return {-1, -1}
+end
+
+if c.State == HALF_OPEN or should_trip(p, c, now) then
+	open_circuit(c, now)
+	save(KEYS[1], c, ttl)
+	return {from, OPEN, cjson.encode(c)}
+end
+
+save(KEYS[1], c, ttl)
+return {-1, -1}
+`)
+
+var recordSuccessScript = redis.NewScript(circuitScript + `
+local p, now, ttl, out = cjson.decode(ARGV[1]), tonumber(ARGV[2]), ARGV[3], cjson.decode(ARGV[4])
+
+local c = load(KEYS[1])
+if c == nil then
+	c = closed()
+	save(KEYS[1], c, ttl)
+end
+
+if c.State == DISABLED then
+	if p.Window ~= NO_WINDOW then
+		record(p, c.Window, false, out.Slow, now)
+		save(KEYS[1], c, ttl)
+	end
+	return {-1, -1}
+end
+
+if c.State == CLOSED then
+	if p.Window == NO_WINDOW then
+		return {-1, -1}
+	end
+
+	record(p, c.Window, false, out.Slow, now)
+
+	if should_trip(p, c, now) then
+		open_circuit(c, now)
+		save(KEYS[1], c, ttl)
+		return {CLOSED, OPEN, cjson.encode(c)}
+	end
+
+	save(KEYS[1], c, ttl)
+	return {-1, -1}
+end
+
+if c.State == HALF_OPEN then
+	if out.Probe and c.Probes > 0 then
+		c.Probes = c.Probes - 1
+	end
+	c.ProbeSuccesses = c.ProbeSuccesses + 1
+
+	if c.ProbeSuccesses < p.HalfOpenSuccessThreshold then
+		save(KEYS[1], c, ttl)
+		return {-1, -1}
+	end
+
+	close_circuit(c)
+	save(KEYS[1], c, ttl)
+	return {HALF_OPEN, CLOSED, cjson.encode(c)}
+end
+
+return {-1, -1}
+`)
+
+var compareAndSetScript = redis.NewScript(circuitScript + `
+if (redis.call("get", KEYS[1]) or "") == ARGV[1] then
+	set(KEYS[1], ARGV[2], ARGV[3])
+	return 1
+end
+return 0
+`)
+
+// RedisStore redis cache which runs circuit transitions as lua scripts, in a
+// single round-trip & without a DistLock
+type RedisStore struct {
+	cache         *RedisCache
+	updateRetries int
+}
+
+// storedCircuit circuit as held by RedisStore, times are unix milliseconds
+// so they can be read by its scripts
+type storedCircuit struct {
+	State          schema.State
+	Failures       int
+	OpenedAt       int64
+	Window         storedWindow
+	Probes         int
+	ProbeSuccesses int
+	ProbesExpireAt int64
+	SchemaVersion  int
+}
+
+type storedWindow struct {
+	Seq     int64
+	Buckets []schema.Bucket `json:",omitempty"`
+}
+
+// NewRedisStore ctor
+func NewRedisStore(client ClientOption, options ...RedisCacheOption) *RedisStore {
+	store := NewRedisStoreFromClient(newClient(client), options...)
+	store.cache.owned = true
+	return store
+}
+
+// NewRedisStoreFromClient ctor, using a Client, ClusterClient, FailoverClient or Ring.
+// LegacyKeys doesn't apply, as no earlier version stored circuits in its format
+func NewRedisStoreFromClient(client redis.UniversalClient, options ...RedisCacheOption) *RedisStore {
+	cache := NewRedisCacheFromClient(client, options...)
+	if cache.legacy {
+		cache.logError("LegacyKeys doesn't apply to a RedisStore", nil)
+		cache.legacy = false
+	}
+
+	return &RedisStore{
+		cache:         cache,
+		updateRetries: 10,
+	}
+}
+
+// Get gets item from cache
+func (store *RedisStore) Get(ID string) (*schema.Circuit, error) {
+	val, err := store.cache.client.Get(store.cache.keys.circuit(ID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeStoredCircuit(val)
+}
+
+// Set sets item in cache
+func (store *RedisStore) Set(ID string, circuit *schema.Circuit) error {
+	cir, err := encodeStoredCircuit(circuit)
+	if err != nil {
+		return err
+	}
+	ttl := time.Millisecond * time.Duration(store.cache.ttl)
+	return store.cache.client.Set(store.cache.keys.circuit(ID), cir, ttl).Err()
+}
+
+// Close closes the store's client, if the store created it
+func (store *RedisStore) Close() error {
+	return store.cache.Close()
+}
+
+// List lists the IDs of circuits starting with prefix
+func (store *RedisStore) List(prefix string) ([]string, error) {
+	return store.cache.List(prefix)
+}
+
+// Delete deletes item from cache
+func (store *RedisStore) Delete(ID string) error {
+	return store.cache.Delete(ID)
+}
+
+// Expire expires item after ttl (<= 0 never), until it's next set
+func (store *RedisStore) Expire(ID string, ttl time.Duration) error {
+	return store.cache.Expire(ID, ttl)
+}
+
+// CheckState gets a circuit, creating it closed if it doesn't exist
+func (store *RedisStore) CheckState(ID string) (*schema.Circuit, error) {
+	val, err := checkStateScript.Run(store.cache.client, []string{store.cache.keys.circuit(ID)}, store.cache.ttl).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeStoredCircuit(val.(string))
+}
+
+// TryHalfOpen moves an open circuit past its grace period to half open, and
+// admits (or rejects) a call against the circuit's probe limit
+func (store *RedisStore) TryHalfOpen(ID string, policy schema.Policy, now time.Time) (schema.Admission, *schema.Transition, *schema.Circuit, error) {
+	p, err := json.Marshal(policy)
+	if err != nil {
+		return schema.RejectedOpen, nil, nil, err
+	}
+
+	val, err := tryHalfOpenScript.Run(store.cache.client, []string{store.cache.keys.circuit(ID)}, p, toMs(now), store.cache.ttl).Result()
+	if err != nil {
+		return schema.RejectedOpen, nil, nil, err
+	}
+
+	res := val.([]interface{})
+	transition, circuit, err := transitioned(res[1:])
+	return schema.Admission(res[0].(int64)), transition, circuit, err
+}
+
+// RecordSuccess records a successful call
+func (store *RedisStore) RecordSuccess(ID string, policy schema.Policy, outcome schema.Outcome, now time.Time) (*schema.Transition, *schema.Circuit, error) {
+	return store.record(recordSuccessScript, ID, policy, outcome, now)
+}
+
+// RecordFailure records a failed call
+func (store *RedisStore) RecordFailure(ID string, policy schema.Policy, outcome schema.Outcome, now time.Time) (*schema.Transition, *schema.Circuit, error) {
+	return store.record(recordFailureScript, ID, policy, outcome, now)
+}
+
+// Update applies fn to a circuit, retrying if the circuit changes underneath it
+func (store *RedisStore) Update(ID string, fn func(circuit *schema.Circuit)) error {
+	for i := 0; i < store.updateRetries; i++ {
+		val, err := store.cache.client.Get(store.cache.keys.circuit(ID)).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+
+		circuit := &schema.Circuit{State: schema.Closed}
+		if err != redis.Nil {
+			circuit, err = decodeStoredCircuit(val)
+			if err != nil {
+				return err
+			}
+		}
+
+		fn(circuit)
+
+		cir, err := encodeStoredCircuit(circuit)
+		if err != nil {
+			return err
+		}
+
+		ok, err := compareAndSetScript.Run(store.cache.client, []string{store.cache.keys.circuit(ID)}, val, cir, store.cache.ttl).Result()
+		if err != nil {
+			return err
+		}
+		if ok.(int64) == 1 {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Could not update ID %s, too much contention", ID)
+}
+
+func (store *RedisStore) record(script *redis.Script, ID string, policy schema.Policy, outcome schema.Outcome, now time.Time) (*schema.Transition, *schema.Circuit, error) {
+	p, err := json.Marshal(policy)
+	if err != nil {
+		return nil, nil, err
+	}
+	o, err := json.Marshal(outcome)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	val, err := script.Run(store.cache.client, []string{store.cache.keys.circuit(ID)}, p, toMs(now), store.cache.ttl, o).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	return transitioned(val.([]interface{}))
+}
+
+// transitioned the transition a script made ({from, to, circuit}), & the
+// circuit after it, nil if it made none
+func transitioned(res []interface{}) (*schema.Transition, *schema.Circuit, error) {
+	from, to := res[0].(int64), res[1].(int64)
+	if from < 0 || to < 0 {
+		return nil, nil, nil
+	}
+
+	circuit, err := decodeStoredCircuit(res[2].(string))
+	if err != nil {
+		return nil, nil, err
+	}
+	return &schema.Transition{From: schema.State(from), To: schema.State(to)}, circuit, nil
+}
+
+func encodeStoredCircuit(circuit *schema.Circuit) ([]byte, error) {
+	return json.Marshal(storedCircuit{
+		State:          circuit.State,
+		Failures:       circuit.Failures,
+		OpenedAt:       toMs(circuit.OpenedAt),
+		Window:         storedWindow{circuit.Window.Seq, circuit.Window.Buckets},
+		Probes:         circuit.Probes,
+		ProbeSuccesses: circuit.ProbeSuccesses,
+		ProbesExpireAt: toMs(circuit.ProbesExpireAt),
+		SchemaVersion:  schema.CurrentSchemaVersion,
+	})
+}
+
+func decodeStoredCircuit(val string) (*schema.Circuit, error) {
+	stored := storedCircuit{}
+	if err := json.Unmarshal([]byte(val), &stored); err != nil {
+		return nil, err
+	}
+
+	circuit := &schema.Circuit{
+		State:          stored.State,
+		Failures:       stored.Failures,
+		OpenedAt:       fromMs(stored.OpenedAt),
+		Window:         schema.Window{Seq: stored.Window.Seq, Buckets: stored.Window.Buckets},
+		Probes:         stored.Probes,
+		ProbeSuccesses: stored.ProbeSuccesses,
+		ProbesExpireAt: fromMs(stored.ProbesExpireAt),
+		SchemaVersion:  stored.SchemaVersion,
+	}
+	if err := schema.Migrate(circuit); err != nil {
+		return nil, err
+	}
+	return circuit, nil
+}
+
+func toMs(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromMs(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/danielglennross/go-dcb/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoredCircuitRoundTrips(t *testing.T) {
+	now := time.Unix(1700000000, 123*int64(time.Millisecond))
+
+	circuit := &schema.Circuit{
+		State:    schema.HalfOpen,
+		Failures: 3,
+		OpenedAt: now,
+		Window: schema.Window{
+			Seq:     4,
+			Buckets: []schema.Bucket{{Epoch: 3, Calls: 2, Failures: 1, SlowCalls: 1}},
+		},
+		Probes:         1,
+		ProbeSuccesses: 2,
+		ProbesExpireAt: now.Add(time.Second),
+		SchemaVersion:  schema.CurrentSchemaVersion,
+	}
+
+	raw, err := encodeStoredCircuit(circuit)
+	require.NoError(t, err)
+
+	decoded, err := decodeStoredCircuit(string(raw))
+	require.NoError(t, err)
+	require.True(t, circuit.OpenedAt.Equal(decoded.OpenedAt))
+	require.True(t, circuit.ProbesExpireAt.Equal(decoded.ProbesExpireAt))
+
+	decoded.OpenedAt, decoded.ProbesExpireAt = circuit.OpenedAt, circuit.ProbesExpireAt
+	require.Equal(t, circuit, decoded)
+}
+
+func TestStoredCircuitKeepsZeroTimes(t *testing.T) {
+	raw, err := encodeStoredCircuit(&schema.Circuit{State: schema.Closed})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"State":0,"Failures":0,"OpenedAt":0,"Window":{"Seq":0},"Probes":0,"ProbeSuccesses":0,"ProbesExpireAt":0,"SchemaVersion":1}`, string(raw))
+
+	decoded, err := decodeStoredCircuit(string(raw))
+	require.NoError(t, err)
+	require.True(t, decoded.OpenedAt.IsZero())
+	require.True(t, decoded.ProbesExpireAt.IsZero())
+}
+
+func TestStoredCircuitDecodesScriptOutput(t *testing.T) {
+	// as written by the scripts' cjson, which drops empty tables
+	decoded, err := decodeStoredCircuit(`{"SchemaVersion":1,"State":1,"Failures":2,"OpenedAt":1700000000123,"Window":{"Seq":0},"Probes":0,"ProbeSuccesses":0,"ProbesExpireAt":0}`)
+	require.NoError(t, err)
+	require.Equal(t, schema.Open, decoded.State)
+	require.Equal(t, 2, decoded.Failures)
+	require.Equal(t, int64(1700000000123), decoded.OpenedAt.UnixNano()/int64(time.Millisecond))
+	require.Nil(t, decoded.Window.Buckets)
+}
+
+func TestStoredCircuitMigratesOnDecode(t *testing.T) {
+	decoded, err := decodeStoredCircuit(`{"State":1,"Window":{"Seq":0}}`)
+	require.NoError(t, err)
+	require.Equal(t, schema.CurrentSchemaVersion, decoded.SchemaVersion)
+
+	_, err = decodeStoredCircuit(`{"State":1,"SchemaVersion":99}`)
+	require.True(t, errors.Is(err, schema.ErrSchemaVersion))
+}
@@ -0,0 +1,307 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielglennross/go-dcb/schema"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRedis a client for the Redis at DCB_REDIS_ADDR, flushing database 14
+// (the root package's tests use 15). Without DCB_REDIS_ADDR the test is skipped
+func newTestRedis(t *testing.T) *redis.Client {
+	addr := os.Getenv("DCB_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("DCB_REDIS_ADDR not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr, DB: 14})
+	require.NoError(t, client.FlushDB().Err())
+
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestRedisStoreWithoutTTL(t *testing.T) {
+	client := newTestRedis(t)
+	store := NewRedisStoreFromClient(client, TTL(0))
+	policy := schema.Policy{Threshold: 0, GracePeriodMs: 1000}
+
+	circuit, err := store.CheckState("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Closed, circuit.State)
+
+	transition, opened, err := store.RecordFailure("id", policy, schema.Outcome{}, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, &schema.Transition{From: schema.Closed, To: schema.Open}, transition)
+	require.Equal(t, schema.Open, opened.State)
+	require.Equal(t, 1, opened.Failures)
+
+	require.NoError(t, store.Update("id", func(circuit *schema.Circuit) {
+		circuit.State = schema.Isolate
+	}))
+
+	ttl, err := client.PTTL(store.cache.keys.circuit("id")).Result()
+	require.NoError(t, err)
+	require.True(t, ttl < 0)
+}
+
+func TestRedisStoreScriptsKeepSetCircuit(t *testing.T) {
+	store := NewRedisStoreFromClient(newTestRedis(t), TTL(60000))
+	policy := schema.Policy{Window: schema.CountWindow, WindowSize: 4, MinimumCalls: 10, FailureRateThreshold: 50}
+	openedAt := time.Unix(1700000000, 0)
+
+	require.NoError(t, store.Set("id", &schema.Circuit{
+		State:    schema.Disabled,
+		Failures: 1,
+		OpenedAt: openedAt,
+		Window: schema.Window{
+			Seq:     1,
+			Buckets: []schema.Bucket{{Epoch: 0, Calls: 1, Failures: 1}, {}, {}, {}},
+		},
+	}))
+
+	_, _, err := store.RecordFailure("id", policy, schema.Outcome{Slow: true}, time.Now())
+	require.NoError(t, err)
+
+	circuit, err := store.Get("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Disabled, circuit.State)
+	require.Equal(t, 2, circuit.Failures)
+	require.True(t, openedAt.Equal(circuit.OpenedAt))
+	require.Equal(t, int64(2), circuit.Window.Seq)
+	require.Equal(t, schema.Bucket{Epoch: 1, Calls: 1, Failures: 1, SlowCalls: 1}, circuit.Window.Buckets[1])
+}
+
+func TestRedisCacheCompareAndSetWithoutTTL(t *testing.T) {
+	client := newTestRedis(t)
+	cache := NewRedisCacheFromClient(client, TTL(0))
+
+	ok, err := cache.CompareAndSet("id", &schema.Circuit{State: schema.Open}, 0)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	circuit, version, err := cache.GetVersioned("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Open, circuit.State)
+	require.Equal(t, int64(1), version)
+
+	ok, err = cache.CompareAndSet("id", &schema.Circuit{State: schema.Closed}, 0)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ttl, err := client.PTTL(cache.keys.circuit("id")).Result()
+	require.NoError(t, err)
+	require.True(t, ttl < 0)
+}
+
+func TestWatchdogHeldUnderHalfTTL(t *testing.T) {
+	rl := NewRedLockFromClients(nil, TTLms(100), Watchdog(100))
+	require.Equal(t, 49, rl.watchdogMs)
+
+	rl = NewRedLockFromClients(nil, Watchdog(20), TTLms(100))
+	require.Equal(t, 20, rl.watchdogMs)
+}
+
+func TestWatchdogHoldsLockPastTTL(t *testing.T) {
+	client := newTestRedis(t)
+	rl := NewRedLockFromClients([]redis.UniversalClient{client}, TTLms(100), Watchdog(30), RetryCount(1), RetryDelayMs(1))
+
+	_, err := rl.RunCritical("id", func() (interface{}, error) {
+		time.Sleep(300 * time.Millisecond)
+
+		_, err := rl.RunCritical("id", func() (interface{}, error) {
+			t.Fatal("lock held twice")
+			return nil, nil
+		})
+		require.True(t, errors.Is(err, schema.ErrLockNotAcquired))
+		return true, nil
+	})
+	require.NoError(t, err)
+}
+
+func TestWatchdogReportsLostLease(t *testing.T) {
+	client := newTestRedis(t)
+	rl := NewRedLockFromClients([]redis.UniversalClient{client}, TTLms(100), Watchdog(20))
+
+	res, err := rl.RunCritical("id", func() (interface{}, error) {
+		// the lease expires, or another holder takes it
+		require.NoError(t, client.Del(rl.keys.lock("id")).Err())
+		time.Sleep(60 * time.Millisecond)
+		return 5, nil
+	})
+	require.Equal(t, 5, res)
+	require.True(t, errors.Is(err, schema.ErrLockLost))
+}
+
+func TestExtendRenewsHeldLease(t *testing.T) {
+	client := newTestRedis(t)
+	rl := NewRedLockFromClients([]redis.UniversalClient{client}, TTLms(100))
+
+	lease, err := rl.Lock("id")
+	require.NoError(t, err)
+	defer rl.Unlock(lease)
+
+	time.Sleep(60 * time.Millisecond)
+	require.NoError(t, rl.Extend(lease))
+
+	time.Sleep(60 * time.Millisecond)
+	require.Equal(t, int64(1), client.Exists(rl.keys.lock("id")).Val())
+
+	require.NoError(t, client.Del(rl.keys.lock("id")).Err())
+	require.True(t, errors.Is(rl.Extend(lease), schema.ErrLockLost))
+}
+
+func TestRedisCacheSetFencedWithoutTTL(t *testing.T) {
+	client := newTestRedis(t)
+	cache := NewRedisCacheFromClient(client, TTL(0))
+
+	require.NoError(t, cache.SetFenced("id", &schema.Circuit{State: schema.Open}, 2))
+
+	circuit, err := cache.Get("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Open, circuit.State)
+
+	ttl, err := client.PTTL(cache.keys.circuit("id")).Result()
+	require.NoError(t, err)
+	require.True(t, ttl < 0)
+
+	err = cache.SetFenced("id", &schema.Circuit{State: schema.Closed}, 1)
+	require.True(t, errors.Is(err, schema.ErrStaleFence))
+}
+
+func TestFenceKeysShareCircuitLifetime(t *testing.T) {
+	client := newTestRedis(t)
+	cache := NewRedisCacheFromClient(client, TTL(60000))
+	rl := NewRedLockFromClients([]redis.UniversalClient{client}, FenceTTLms(60000))
+
+	lease, err := rl.Lock("id")
+	require.NoError(t, err)
+	rl.Unlock(lease)
+	require.NoError(t, cache.SetFenced("id", &schema.Circuit{State: schema.Open}, lease.Fence))
+
+	for _, key := range []string{rl.keys.fence("id"), cache.keys.acceptedFence("id")} {
+		ttl, err := client.PTTL(key).Result()
+		require.NoError(t, err)
+		require.True(t, ttl > 0, key)
+	}
+
+	require.NoError(t, cache.Expire("id", 0))
+	ttl, err := client.PTTL(cache.keys.acceptedFence("id")).Result()
+	require.NoError(t, err)
+	require.True(t, ttl < 0)
+	require.Equal(t, int64(1), client.Exists(cache.keys.acceptedFence("id")).Val())
+
+	require.NoError(t, cache.Delete("id"))
+	require.Equal(t, int64(0), client.Exists(cache.keys.circuit("id"), cache.keys.acceptedFence("id")).Val())
+}
+
+func TestExpiredFenceCounterStaysPastAcceptedFence(t *testing.T) {
+	client := newTestRedis(t)
+	cache := NewRedisCacheFromClient(client, TTL(0))
+	rl := NewRedLockFromClients([]redis.UniversalClient{client})
+
+	lease, err := rl.Lock("id")
+	require.NoError(t, err)
+	rl.Unlock(lease)
+	require.NoError(t, cache.SetFenced("id", &schema.Circuit{State: schema.Open}, lease.Fence))
+
+	// the counter expires, while the circuit is kept
+	require.NoError(t, client.Del(rl.keys.fence("id")).Err())
+	time.Sleep(2 * time.Millisecond)
+
+	next, err := rl.Lock("id")
+	require.NoError(t, err)
+	rl.Unlock(next)
+	require.True(t, next.Fence > lease.Fence)
+	require.NoError(t, cache.SetFenced("id", &schema.Circuit{State: schema.Closed}, next.Fence))
+}
+
+func TestTagEscapesBraces(t *testing.T) {
+	for _, ID := range []string{"billing.invoices", "a{b}", `a\(b`, "}{", `\0`, ""} {
+		tagged := tag(ID)
+		require.False(t, strings.ContainsAny(tagged, "{}"), ID)
+		require.NotEqual(t, "", tagged)
+		require.Equal(t, ID, untag(tagged))
+	}
+	require.NotEqual(t, tag("a{b"), tag(`a\(b`))
+}
+
+func TestListReturnsEscapedIDs(t *testing.T) {
+	cache := NewRedisCacheFromClient(newTestRedis(t), TTL(0))
+
+	for _, ID := range []string{"a{b}", "a}", ""} {
+		require.NoError(t, cache.Set(ID, &schema.Circuit{}))
+	}
+
+	IDs, err := cache.List("a")
+	require.NoError(t, err)
+	sort.Strings(IDs)
+	require.Equal(t, []string{"a{b}", "a}"}, IDs)
+
+	IDs, err = cache.List("")
+	require.NoError(t, err)
+	require.Len(t, IDs, 3)
+}
+
+func TestListEscapesKeyPrefix(t *testing.T) {
+	client := newTestRedis(t)
+	globbed := NewRedisCacheFromClient(client, KeyPrefix("a*:"), TTL(0))
+	other := NewRedisCacheFromClient(client, KeyPrefix("ab:"), TTL(0))
+
+	require.NoError(t, globbed.Set("x", &schema.Circuit{}))
+	require.NoError(t, other.Set("y", &schema.Circuit{}))
+
+	IDs, err := globbed.List("")
+	require.NoError(t, err)
+	require.Equal(t, []string{"x"}, IDs)
+}
+
+func TestLegacyLockKeysExcludeOldNodes(t *testing.T) {
+	client := newTestRedis(t)
+	rl := NewRedLockFromClients([]redis.UniversalClient{client}, LegacyLockKeys(), RetryCount(1), RetryDelayMs(1))
+
+	// held by a node keying locks by bare ID
+	require.NoError(t, client.Set("id-lock", "old", time.Minute).Err())
+	_, err := rl.Lock("id")
+	require.True(t, errors.Is(err, schema.ErrLockNotAcquired))
+	require.Equal(t, int64(0), client.Exists(rl.keys.lock("id")).Val())
+
+	require.NoError(t, client.Del("id-lock").Err())
+	lease, err := rl.Lock("id")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), client.Exists(rl.keys.lock("id"), "id-lock").Val())
+	require.NoError(t, rl.Extend(lease))
+
+	rl.Unlock(lease)
+	require.Equal(t, int64(0), client.Exists(rl.keys.lock("id"), "id-lock").Val())
+}
+
+func TestLegacyKeysCarryCircuitsOver(t *testing.T) {
+	client := newTestRedis(t)
+	cache := NewRedisCacheFromClient(client, LegacyKeys(), TTL(0))
+
+	// as written by RedisCache before keys were hash tagged
+	require.NoError(t, client.Set("id", `{"State":1,"Failures":2,"OpenedAt":"2026-10-16T10:43:28.123Z"}`, 0).Err())
+
+	circuit, version, err := cache.GetVersioned("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Open, circuit.State)
+	require.Equal(t, 2, circuit.Failures)
+	require.True(t, time.Date(2026, 10, 16, 10, 43, 28, 123e6, time.UTC).Equal(circuit.OpenedAt))
+	require.Equal(t, int64(0), version)
+
+	ok, err := cache.CompareAndSet("id", circuit, version)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(1), client.Exists(cache.keys.circuit("id")).Val())
+
+	store := NewRedisStoreFromClient(client, LegacyKeys(), TTL(0))
+	require.False(t, store.cache.legacy)
+}
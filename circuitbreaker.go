@@ -1,8 +1,13 @@
-package main
+// Package dcb provides a distributed circuit breaker, backed by a shared
+// cache and distributed lock (see the cache package).
+package dcb
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/danielglennross/go-dcb/policies"
@@ -12,52 +17,52 @@ import (
 // CircuitBreakerFn circuit breaker func
 type CircuitBreakerFn func() (interface{}, error)
 
+// CircuitBreakerContextFn context aware circuit breaker func
+type CircuitBreakerContextFn func(ctx context.Context) (interface{}, error)
+
 // FireDynamic circuit breaker contract
 type FireDynamic interface {
 	Fire(ID string, args ...interface{}) (interface{}, error)
+	FireContext(ctx context.Context, ID string, args ...interface{}) (interface{}, error)
 }
 
 // FireStatic circuit breaker contract
 type FireStatic interface {
 	Fire(ID string, fn CircuitBreakerFn) (interface{}, error)
+	FireContext(ctx context.Context, ID string, fn CircuitBreakerContextFn) (interface{}, error)
 }
 
-type handler func(ID string, breaker *CircuitBreaker) (interface{}, error)
-
-type eventHandler func(ID string)
-
 type fnResult struct {
 	res interface{}
 	err error
 }
 
-type circuitChan struct {
-	ID    string
-	state schema.State
-}
-
-type fallbackChan struct {
-	ID  string
-	err error
-}
-
-type failCondition func(err error) bool
+// FailConditionFn condition which to fail the circuit breaker
+type FailConditionFn func(err error) bool
 
 // CircuitBreaker regular
 type CircuitBreaker struct {
 	*options
-	circuitChan                      chan circuitChan
-	fallbackChan                     chan fallbackChan
-	closed, open, halfOpen, fallback eventHandler
-	exit                             chan bool
-	cache                            schema.Cache
-	lock                             schema.DistLock
+	dispatcher     *dispatcher
+	publisher      *publisher
+	listeners      map[listenerKind][]EventHandler
+	listenersMutex sync.RWMutex
+	closeMutex     sync.RWMutex // guards closed, held while a call enters
+	closed         bool
+	closeOnce      sync.Once
+	storesOnce     sync.Once
+	inflight       sync.WaitGroup
+	cache          schema.Cache
+	lock           schema.DistLock
+	store          store
+	unsubscribe    func() error
 }
 
 // CircuitBreakerDynamic circuit breaker
 type CircuitBreakerDynamic struct {
 	*CircuitBreaker
-	fn interface{}
+	fn          interface{}
+	withContext bool
 }
 
 // Options circuit breaker options
@@ -67,83 +72,203 @@ type options struct {
 
 	timeoutMs int64
 
-	failCondition failCondition
+	failCondition FailConditionFn
 	backoff       policies.Backoff
 	retry         int
 
+	window               schema.WindowKind
+	windowSize           int
+	windowSizeMs         int64
+	windowBucketCount    int
+	failureRateThreshold float64
+	minimumCalls         int
+
+	slowCallDurationMs    int64
+	slowCallRateThreshold float64
+
+	halfOpenMaxProbes        int
+	halfOpenSuccessThreshold int
+
+	casRetries int
+
+	lockFailurePolicy LockFailurePolicy
+
+	notifier schema.Notifier
+	node     string
+
+	separator string
+
+	eventQueueSize      int
+	eventOverflow       OverflowPolicy
+	eventBlockTimeoutMs int64
+
+	closeStores bool
+
+	fallback FallbackFn
+
 	logError schema.Log
 	logInfo  schema.Log
 }
 
-type circuitBreakerOption func(*CircuitBreaker)
+// LockFailurePolicy what Fire does when a circuit's lock can't be acquired
+type LockFailurePolicy int
+
+const (
+	// FailOpen run the call, without reading or updating its circuit
+	FailOpen LockFailurePolicy = iota
+	// FailClosed reject the call, raising a fallback
+	FailClosed
+)
+
+// CircuitBreakerOption circuit breaker option
+type CircuitBreakerOption func(*CircuitBreaker)
 
 // FailCondition condition which to fail the circuit breaker
-func FailCondition(failCondition failCondition) circuitBreakerOption {
+func FailCondition(failCondition FailConditionFn) CircuitBreakerOption {
 	return func(cb *CircuitBreaker) {
 		cb.failCondition = failCondition
 	}
 }
 
 // GracePeriodMs grace period in milliseconds
-func GracePeriodMs(gp int64) circuitBreakerOption {
+func GracePeriodMs(gp int64) CircuitBreakerOption {
 	return func(cb *CircuitBreaker) {
 		cb.gracePeriodMs = gp
 	}
 }
 
 // Threshold threshold (< 1)
-func Threshold(t int) circuitBreakerOption {
+func Threshold(t int) CircuitBreakerOption {
 	return func(cb *CircuitBreaker) {
 		cb.threshold = t
 	}
 }
 
 // TimeoutMs in milliseconds
-func TimeoutMs(t int64) circuitBreakerOption {
+func TimeoutMs(t int64) CircuitBreakerOption {
 	return func(cb *CircuitBreaker) {
 		cb.timeoutMs = t
 	}
 }
 
 // BackoffMs in milliseconds
-func BackoffMs(b policies.Backoff) circuitBreakerOption {
+func BackoffMs(b policies.Backoff) CircuitBreakerOption {
 	return func(cb *CircuitBreaker) {
 		cb.backoff = b
 	}
 }
 
-// Retry count
-func Retry(r int) circuitBreakerOption {
+// Retry attempts per call (< 1 is 1)
+func Retry(r int) CircuitBreakerOption {
 	return func(cb *CircuitBreaker) {
 		cb.retry = r
 	}
 }
 
+// SlidingWindowCount trip on the failure rate of the last size calls,
+// rather than on Threshold
+func SlidingWindowCount(size int) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.window = schema.CountWindow
+		cb.windowSize = size
+	}
+}
+
+// SlidingWindowTimeMs trip on the failure rate of calls in the last sizeMs,
+// split into buckets, rather than on Threshold
+func SlidingWindowTimeMs(sizeMs int64, buckets int) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.window = schema.TimeWindow
+		cb.windowSizeMs = sizeMs
+		cb.windowBucketCount = buckets
+	}
+}
+
+// FailureRateThreshold failure percentage (0-100) of a sliding window at which to trip
+func FailureRateThreshold(pct float64) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.failureRateThreshold = pct
+	}
+}
+
+// MinimumCalls calls a sliding window must hold before it can trip
+func MinimumCalls(m int) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.minimumCalls = m
+	}
+}
+
+// SlowCallDurationMs calls taking at least this long, in milliseconds, are slow
+func SlowCallDurationMs(d int64) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.slowCallDurationMs = d
+	}
+}
+
+// SlowCallRateThreshold slow call percentage (0-100) of a sliding window at which to trip
+func SlowCallRateThreshold(pct float64) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.slowCallRateThreshold = pct
+	}
+}
+
+// HalfOpenMaxProbes concurrent calls allowed through a half open circuit,
+// across every node sharing the cache (< 1 is unlimited)
+func HalfOpenMaxProbes(p int) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.halfOpenMaxProbes = p
+	}
+}
+
+// HalfOpenSuccessThreshold consecutive successes needed to close a half open circuit
+func HalfOpenSuccessThreshold(t int) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.halfOpenSuccessThreshold = t
+	}
+}
+
+// CASRetries attempts at an optimistic update, when the breaker has no
+// DistLock and the cache is a VersionedCache
+func CASRetries(r int) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.casRetries = r
+	}
+}
+
+// LockFailure policy for when a circuit's lock can't be acquired (default FailOpen)
+func LockFailure(p LockFailurePolicy) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.lockFailurePolicy = p
+	}
+}
+
 // LogError log error delegate
-func LogError(le schema.Log) circuitBreakerOption {
+func LogError(le schema.Log) CircuitBreakerOption {
 	return func(cb *CircuitBreaker) {
 		cb.logError = le
 	}
 }
 
 // LogInfo log info delegate
-func LogInfo(li schema.Log) circuitBreakerOption {
+func LogInfo(li schema.Log) CircuitBreakerOption {
 	return func(cb *CircuitBreaker) {
 		cb.logInfo = li
 	}
 }
 
 // NewCircuitBreaker ctor
-func NewCircuitBreaker(cache schema.Cache, lock schema.DistLock, options ...circuitBreakerOption) (*CircuitBreaker, error) {
+func NewCircuitBreaker(cache schema.Cache, lock schema.DistLock, options ...CircuitBreakerOption) (*CircuitBreaker, error) {
 	cb := new(CircuitBreaker)
 
-	initCircuitBreaker(cb, cache, lock, options...)
+	if err := initCircuitBreaker(cb, cache, lock, options...); err != nil {
+		return nil, err
+	}
 
 	return cb, nil
 }
 
 // NewCircuitBreakerDynamic ctor
-func NewCircuitBreakerDynamic(fn interface{}, cache schema.Cache, lock schema.DistLock, options ...circuitBreakerOption) (*CircuitBreakerDynamic, error) {
+func NewCircuitBreakerDynamic(fn interface{}, cache schema.Cache, lock schema.DistLock, options ...CircuitBreakerOption) (*CircuitBreakerDynamic, error) {
 	fnType := reflect.TypeOf(fn)
 
 	if fnType.NumOut() != 2 {
@@ -159,43 +284,81 @@ func NewCircuitBreakerDynamic(fn interface{}, cache schema.Cache, lock schema.Di
 		return nil, fmt.Errorf("Invalid function")
 	}
 
+	contextType := reflect.TypeOf((*context.Context)(nil)).Elem()
+
 	cb := new(CircuitBreakerDynamic)
+	cb.CircuitBreaker = new(CircuitBreaker)
 	cb.fn = fn
+	cb.withContext = fnType.NumIn() > 0 && fnType.In(0) == contextType
 
-	initCircuitBreaker(cb.CircuitBreaker, cache, lock, options...)
+	if err := initCircuitBreaker(cb.CircuitBreaker, cache, lock, options...); err != nil {
+		return nil, err
+	}
 
 	return cb, nil
 }
 
-// Destroy disposes of the circuit breaker
+// Destroy disposes of the circuit breaker, without waiting for in-flight calls
 func (breaker *CircuitBreaker) Destroy() {
-	close(breaker.exit) // kill go routine
-	close(breaker.circuitChan)
-	close(breaker.fallbackChan)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = breaker.Close(ctx)
 }
 
 // Isolate manually open (and hold open) a circuit breaker
 func (breaker *CircuitBreaker) Isolate(ID string) bool {
-	return breaker.safelyUpdateCircuit(ID, func(circuit *schema.Circuit) {
+	var from schema.State
+	circuit, ok := breaker.safelyUpdateCircuit(ID, func(circuit *schema.Circuit) {
+		from = circuit.State
 		circuit.State = schema.Isolate
+	})
+	if ok {
+		breaker.emit(ID, &schema.Transition{From: from, To: schema.Isolate}, circuit, nil)
+		breaker.raiseFallback(ID, fmt.Errorf("Ioslating ID %s", ID))
+	}
+	return ok
+}
 
-		breaker.circuitChan <- circuitChan{ID, schema.Open}
-		breaker.fallbackChan <- fallbackChan{ID, fmt.Errorf("Ioslating ID %s", ID)}
+// ForceClose hold a circuit closed, its calls are never rejected & failures not counted
+func (breaker *CircuitBreaker) ForceClose(ID string) bool {
+	return breaker.pin(ID, schema.ForceClosed)
+}
+
+// Disable run a circuit as metrics only, its outcomes are counted & events
+// raised, but it never trips & its calls are never rejected
+func (breaker *CircuitBreaker) Disable(ID string) bool {
+	return breaker.pin(ID, schema.Disabled)
+}
+
+// pin moves a circuit to a manual state, with a fresh window
+func (breaker *CircuitBreaker) pin(ID string, state schema.State) bool {
+	var from schema.State
+	circuit, ok := breaker.safelyUpdateCircuit(ID, func(circuit *schema.Circuit) {
+		from = circuit.State
+		closeCircuit(circuit)
+		circuit.State = state
 	})
+	if ok {
+		breaker.emit(ID, &schema.Transition{From: from, To: state}, circuit, nil)
+	}
+	return ok
 }
 
 // Reset resets a circuit to closed
 func (breaker *CircuitBreaker) Reset(ID string) bool {
-	return breaker.safelyUpdateCircuit(ID, func(circuit *schema.Circuit) {
-		circuit.State = schema.Closed
-		circuit.OpenedAt = time.Time{}
-		circuit.Failures = 0
-
-		breaker.circuitChan <- circuitChan{ID, schema.Closed}
+	var from schema.State
+	circuit, ok := breaker.safelyUpdateCircuit(ID, func(circuit *schema.Circuit) {
+		from = circuit.State
+		closeCircuit(circuit)
 	})
+	if ok {
+		breaker.emit(ID, &schema.Transition{From: from, To: schema.Closed}, circuit, nil)
+	}
+	return ok
 }
 
-func initCircuitBreaker(cb *CircuitBreaker, cache schema.Cache, lock schema.DistLock, options ...circuitBreakerOption) {
+func initCircuitBreaker(cb *CircuitBreaker, cache schema.Cache, lock schema.DistLock, opts ...CircuitBreakerOption) error {
+	cb.options = new(options)
 	cb.cache = cache
 	cb.lock = lock
 
@@ -207,130 +370,183 @@ func initCircuitBreaker(cb *CircuitBreaker, cache schema.Cache, lock schema.Dist
 	cb.backoff = &policies.Fixed{WaitDuration: 300 * time.Millisecond}
 	cb.retry = 3
 
-	cb.circuitChan = make(chan circuitChan)
-	cb.fallbackChan = make(chan fallbackChan)
-	cb.exit = make(chan bool)
+	cb.window = schema.NoWindow
+	cb.failureRateThreshold = 50
+	cb.minimumCalls = 10
+	cb.slowCallRateThreshold = 100
+	cb.halfOpenSuccessThreshold = 1
+	cb.casRetries = 10
+	cb.lockFailurePolicy = FailOpen
 
-	nullEventHandler := func(ID string) {}
-	cb.open = nullEventHandler
-	cb.halfOpen = nullEventHandler
-	cb.closed = nullEventHandler
-	cb.fallback = nullEventHandler
+	cb.eventQueueSize = 256
+	cb.eventOverflow = DropNewest
+	cb.eventBlockTimeoutMs = 100
+	cb.listeners = make(map[listenerKind][]EventHandler)
 
 	cb.logError = func(message string, context interface{}) {}
 	cb.logInfo = func(message string, context interface{}) {}
 
-	for _, opt := range options {
+	for _, opt := range opts {
 		opt(cb)
 	}
 
-	go handleEvents(cb)
-}
+	if cb.retry < 1 {
+		cb.retry = 1
+	}
+	if cb.window == schema.CountWindow && cb.windowSize < 1 {
+		cb.windowSize = 1
+	}
+	if cb.window == schema.TimeWindow && cb.windowBucketCount < 1 {
+		cb.windowBucketCount = 1
+	}
 
-func (breaker *CircuitBreaker) safelyUpdateCircuit(ID string, fn func(circuit *schema.Circuit)) bool {
-	res, err := breaker.lock.RunCritical(ID, func() (interface{}, error) {
-		circuit, err := breaker.cache.Get(ID)
-		if err != nil {
-			return false, nil
-		}
+	if ts, ok := cache.(schema.TransitionStore); ok {
+		cb.store = &nativeStore{cb, ts}
+	} else if lock != nil {
+		cb.store = &txStore{cb, &lockTx{cache, lock}}
+	} else if vc, ok := cache.(schema.VersionedCache); ok {
+		cb.store = &txStore{cb, &casTx{vc, cb.casRetries}}
+	} else {
+		return fmt.Errorf("A DistLock is required unless the cache is a TransitionStore or VersionedCache")
+	}
 
-		fn(circuit)
+	if cb.node == "" {
+		cb.node = newNodeID()
+	}
 
-		err = breaker.cache.Set(ID, circuit)
-		if err != nil {
-			return false, nil
-		}
-		return true, nil
-	})
-	if err != nil {
-		return false
-	}
-	return res.(bool)
-}
-
-func handleEvents(breaker *CircuitBreaker) {
-	for {
-		select {
-		case <-breaker.exit:
-			return
-		case f := <-breaker.fallbackChan:
-			breaker.fallback(f.ID)
-		case c := <-breaker.circuitChan:
-			switch c.state {
-			case schema.Closed:
-				breaker.closed(c.ID)
-			case schema.Open:
-				breaker.open(c.ID)
-			case schema.HalfOpen:
-				breaker.halfOpen(c.ID)
-			}
-		}
+	cb.dispatcher = newDispatcher(cb.eventQueueSize, cb.eventOverflow, time.Millisecond*time.Duration(cb.eventBlockTimeoutMs))
+	go cb.dispatcher.run(cb.deliver)
+
+	if cb.notifier != nil {
+		cb.publisher = newPublisher(cb.publish)
+		go cb.publisher.run()
 	}
-}
 
-// OnClosed handle on closed
-func (breaker *CircuitBreaker) OnClosed(closed eventHandler) *CircuitBreaker {
-	breaker.closed = closed
-	return breaker
+	if err := cb.subscribe(); err != nil {
+		cb.Destroy()
+		return err
+	}
+	return nil
 }
 
-// OnOpen handle on opened
-func (breaker *CircuitBreaker) OnOpen(open eventHandler) *CircuitBreaker {
-	breaker.open = open
-	return breaker
+func (breaker *CircuitBreaker) safelyUpdateCircuit(ID string, fn func(circuit *schema.Circuit)) (*schema.Circuit, bool) {
+	var updated *schema.Circuit
+	err := breaker.store.update(ID, func(circuit *schema.Circuit) bool {
+		fn(circuit)
+		updated = circuit.Clone()
+		return true
+	})
+	return updated, err == nil
 }
 
-// OnHalfOpen handle on half opened
-func (breaker *CircuitBreaker) OnHalfOpen(halfOpen eventHandler) *CircuitBreaker {
-	breaker.halfOpen = halfOpen
-	return breaker
+// Fire the static breaker
+func (breaker *CircuitBreaker) Fire(ID string, fn CircuitBreakerFn) (interface{}, error) {
+	return breaker.FireContext(context.Background(), ID, func(ctx context.Context) (interface{}, error) {
+		return fn()
+	})
 }
 
-// OnFallback handle fallack
-func (breaker *CircuitBreaker) OnFallback(fallback eventHandler) *CircuitBreaker {
-	breaker.fallback = fallback
-	return breaker
+// FireContext fire the static breaker, passing each attempt a context which is
+// cancelled when the attempt times out, is retried, or the caller's ctx is done
+func (breaker *CircuitBreaker) FireContext(ctx context.Context, ID string, fn CircuitBreakerContextFn) (interface{}, error) {
+	return breaker.fire(ctx, ID, fn, breaker.fallback)
 }
 
-// Fire the static breaker
-func (breaker *CircuitBreaker) Fire(ID string, fn CircuitBreakerFn) (interface{}, error) {
-	circuit, err := breaker.getOrSetState(ID)
-	if err != nil {
+// fire runs fn through ID's circuit, falling back to fallback (if any) when
+// the call is rejected or fails
+func (breaker *CircuitBreaker) fire(ctx context.Context, ID string, fn CircuitBreakerContextFn, fallback FallbackFn) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
+	if !breaker.enter() {
+		return nil, ErrBreakerClosed
+	}
+	defer breaker.inflight.Done()
 
-	handleOpen := func() (interface{}, error) {
-		err = fmt.Errorf("circuit open for ID: %s", ID)
-		breaker.fallbackChan <- fallbackChan{ID, err}
-		return nil, err
+	reject := func(err error) (interface{}, error) {
+		breaker.raiseFallback(ID, err)
+		return runFallback(ctx, err, fallback)
+	}
+
+	circuit, err := breaker.store.checkState(ID)
+	if err != nil {
+		if !isLockFailure(err) {
+			return nil, storeError(err)
+		}
+		if !breaker.proceedWithoutLock(ID, err) {
+			return reject(storeError(err))
+		}
+		circuit = &schema.Circuit{State: schema.Closed}
 	}
 
 	if circuit.State == schema.Isolate {
-		return handleOpen()
+		return reject(&RejectedError{ID: ID, Reason: ErrCircuitIsolated})
+	}
+
+	// an isolated ancestor overrides even a pinned circuit
+	ancestor, err := breaker.isolatedAncestor(ID)
+	if err != nil {
+		if !isLockFailure(err) {
+			return nil, storeError(err)
+		}
+		if !breaker.proceedWithoutLock(ID, err) {
+			return reject(storeError(err))
+		}
+	}
+	if ancestor != "" {
+		return reject(&RejectedError{ID: ID, Reason: ErrCircuitIsolated, Ancestor: ancestor})
+	}
+
+	if circuit.State == schema.ForceClosed || circuit.State == schema.Disabled {
+		return breaker.trigger(ctx, ID, fn, fallback, false)
 	}
 
-	if circuit.State == schema.Open {
-		reset, err := breaker.tryReset(ID)
+	probe := false
+
+	if circuit.State == schema.Open || (circuit.State == schema.HalfOpen && breaker.halfOpenMaxProbes > 0) {
+		admission, transition, updated, err := breaker.store.tryHalfOpen(ID)
 		if err != nil {
-			return nil, err
+			if !isLockFailure(err) {
+				return nil, storeError(err)
+			}
+			if !breaker.proceedWithoutLock(ID, err) {
+				return reject(storeError(err))
+			}
+			admission = schema.Admitted
 		}
 
-		if !reset {
-			return handleOpen()
+		breaker.emit(ID, transition, updated, nil)
+
+		switch admission {
+		case schema.RejectedOpen:
+			return reject(&RejectedError{ID: ID, Reason: ErrCircuitOpen, RetryAfter: breaker.graceRemaining(circuit)})
+		case schema.RejectedProbeLimit:
+			return reject(&RejectedError{ID: ID, Reason: ErrHalfOpenProbeLimit})
 		}
+
+		probe = admission == schema.AdmittedProbe
 	}
 
 	// Closed || HalfOpen
-	return breaker.trigger(ID, fn)
+	return breaker.trigger(ctx, ID, fn, fallback, probe)
 }
 
 // Fire the dynamic breaker
 func (dBreaker *CircuitBreakerDynamic) Fire(ID string, args ...interface{}) (interface{}, error) {
+	return dBreaker.FireContext(context.Background(), ID, args...)
+}
+
+// FireContext fire the dynamic breaker, if the wrapped function takes a
+// context.Context as its first parameter, it is passed the attempt's context
+func (dBreaker *CircuitBreakerDynamic) FireContext(ctx context.Context, ID string, args ...interface{}) (interface{}, error) {
 	breaker := dBreaker.CircuitBreaker
 	fnc := dBreaker.fn
 
-	fn := func() (interface{}, error) {
+	fn := func(ctx context.Context) (interface{}, error) {
 		var arr []reflect.Value
+		if dBreaker.withContext {
+			arr = append(arr, reflect.ValueOf(ctx))
+		}
 		for _, v := range args {
 			arr = append(arr, reflect.ValueOf(v))
 		}
@@ -349,182 +565,162 @@ func (dBreaker *CircuitBreakerDynamic) Fire(ID string, args ...interface{}) (int
 		return nil, fmt.Errorf("Could not execute fn with args %v", args)
 	}
 
-	return breaker.Fire(ID, fn)
+	return breaker.FireContext(ctx, ID, fn)
 }
 
-func (breaker *CircuitBreaker) getOrSetState(ID string) (*schema.Circuit, error) {
-	res, err := breaker.lock.RunCritical(ID, func() (interface{}, error) {
-		circuit, err := breaker.cache.Get(ID)
-		if err != nil {
-			return nil, err
-		}
-		if circuit == nil {
-			circuit = &schema.Circuit{
-				State:    schema.Closed,
-				OpenedAt: time.Time{},
-				Failures: 0,
-			}
-			err := breaker.cache.Set(ID, circuit)
-			if err != nil {
-				return nil, err
-			}
-		}
-		return circuit, nil
-	})
-	if err != nil {
-		return nil, err
-	}
-	return res.(*schema.Circuit), nil
-}
+// trigger runs fn up to retry times, a failure is recorded against the circuit
+// (and fallback run) once all attempts fail. If the caller's ctx is done the
+// in-flight attempt is cancelled and ctx.Err() returned, without recording a failure
+func (breaker *CircuitBreaker) trigger(ctx context.Context, ID string, fn CircuitBreakerContextFn, fallback FallbackFn, probe bool) (interface{}, error) {
+	var err error
+	var errs []error
+	out := schema.Outcome{Probe: probe}
 
-func (breaker *CircuitBreaker) trigger(ID string, fn CircuitBreakerFn) (interface{}, error) {
-	getTimout := func() <-chan time.Time {
-		return time.After(time.Millisecond * time.Duration(breaker.timeoutMs))
-	}
-
-	var handler handler
-	var timeout <-chan time.Time
-	var result chan fnResult
-
-	var start <-chan time.Time
-	tryCounter := 0
-
-	for tryCounter < breaker.retry {
-		if result == nil {
-			if tryCounter == 0 {
-				start = time.After(0)
-			} else {
-				start = time.After(breaker.backoff.Duration())
+	for tryCounter := 0; tryCounter < breaker.retry; tryCounter++ {
+		if tryCounter > 0 {
+			if waitErr := wait(ctx, breaker.backoff.Duration()); waitErr != nil {
+				breaker.releaseProbe(ID, out)
+				return nil, waitErr
 			}
 		}
 
-		select {
-		case <-timeout:
-			timeout = nil
-			result = nil
-
-			tryCounter++
-			handler = handleFail(fmt.Errorf("A timeout occurred"))
-		case <-start:
-			timeout = getTimout()
-			result = make(chan fnResult, 1)
-
-			go func() {
-				defer func() {
-					e := recover()
-					if e != nil {
-						_, _ = handleFail(fmt.Errorf("A panic occurred"))(ID, breaker)
-						panic(e)
-					}
-				}()
-
-				res, err := fn()
-				result <- fnResult{res, err}
-			}()
-		case value := <-result:
-			if value.err == nil {
-				handler = handleSuccess(value.res)
-				break
-			}
+		var res interface{}
+		start := time.Now()
+		res, err = breaker.attempt(ctx, ID, fn, out)
+		out.Slow = breaker.isSlow(time.Since(start))
+		if err == nil {
+			return breaker.handleSuccess(ID, res, out)
+		}
 
-			if !breaker.failCondition(value.err) {
-				return nil, value.err
-			}
+		if ctx.Err() != nil {
+			breaker.releaseProbe(ID, out)
+			return nil, ctx.Err()
+		}
 
-			timeout = nil
-			result = nil
-			tryCounter++
-			handler = handleFail(value.err)
+		if !breaker.failCondition(err) {
+			breaker.releaseProbe(ID, out)
+			return nil, err
 		}
+		errs = append(errs, err)
 	}
 
-	return handler(ID, breaker)
+	err = &RetriesExhaustedError{ID: ID, Errs: errs}
+	if storeErr := breaker.handleFail(ID, err, out); storeErr != nil {
+		return nil, storeErr
+	}
+	return runFallback(ctx, err, fallback)
 }
 
-func handleFail(err error) handler {
-	return wrapSafeHandler(func(ID string, breaker *CircuitBreaker) (interface{}, error) {
-		circuit, cacheErr := breaker.cache.Get(ID)
-		if cacheErr != nil {
-			return nil, cacheErr
-		}
-		if circuit == nil {
-			return nil, err
-		}
-		if circuit.State == schema.Open {
-			return nil, err
-		}
+// attempt runs fn once, with a context bound by the breaker's timeout
+func (breaker *CircuitBreaker) attempt(ctx context.Context, ID string, fn CircuitBreakerContextFn, out schema.Outcome) (interface{}, error) {
+	attemptCtx, cancel := context.WithTimeout(ctx, time.Millisecond*time.Duration(breaker.timeoutMs))
+	defer cancel()
 
-		circuit.Failures++
+	result := make(chan fnResult, 1)
 
-		if circuit.Failures > breaker.threshold {
-			circuit.State = schema.Open
-			circuit.OpenedAt = time.Now()
-
-			breaker.circuitChan <- circuitChan{ID, schema.Open}
+	breaker.inflight.Add(1)
+	go func() {
+		defer breaker.inflight.Done()
+		defer func() {
+			e := recover()
+			if e != nil {
+				_ = breaker.handleFail(ID, fmt.Errorf("%w: %v", ErrPanic, e), out)
+				panic(e)
+			}
+		}()
+
+		res, err := fn(attemptCtx)
+		result <- fnResult{res, err}
+	}()
+
+	select {
+	case value := <-result:
+		return value.res, value.err
+	case <-attemptCtx.Done():
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
+		return nil, ErrTimeout
+	}
+}
 
-		breaker.fallbackChan <- fallbackChan{ID, err}
+func wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
 
-		breaker.cache.Set(ID, circuit)
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-		return nil, err
-	})
+// graceRemaining how long until an open circuit can half open
+func (breaker *CircuitBreaker) graceRemaining(circuit *schema.Circuit) time.Duration {
+	remaining := time.Until(circuit.OpenedAt.Add(time.Millisecond * time.Duration(breaker.gracePeriodMs)))
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
 }
 
-func handleSuccess(value interface{}) handler {
-	return wrapSafeHandler(func(ID string, breaker *CircuitBreaker) (interface{}, error) {
-		circuit, cacheErr := breaker.cache.Get(ID)
-		if cacheErr != nil {
-			return nil, cacheErr
-		}
-		if circuit == nil {
-			return value, nil
-		}
-		if circuit.State == schema.Closed {
-			return value, nil
-		}
+func (breaker *CircuitBreaker) isSlow(elapsed time.Duration) bool {
+	return breaker.slowCallDurationMs > 0 &&
+		elapsed >= time.Millisecond*time.Duration(breaker.slowCallDurationMs)
+}
 
-		circuit.State = schema.Closed
-		circuit.Failures = 0
+// handleFail records a failed call, returning an error only if it couldn't be recorded
+func (breaker *CircuitBreaker) handleFail(ID string, err error, out schema.Outcome) error {
+	transition, circuit, storeErr := breaker.store.recordFailure(ID, out)
 
-		breaker.cache.Set(ID, circuit)
+	if out.Slow {
+		breaker.raiseSlowCall(ID)
+	}
+	if storeErr != nil && !(isLockFailure(storeErr) && breaker.proceedWithoutLock(ID, storeErr)) {
+		return storeError(storeErr)
+	}
 
-		breaker.circuitChan <- circuitChan{ID, schema.Closed}
+	breaker.emit(ID, transition, circuit, err)
+	breaker.raiseFallback(ID, err)
 
-		return value, nil
-	})
+	return nil
 }
 
-func wrapSafeHandler(handle handler) handler {
-	return func(ID string, breaker *CircuitBreaker) (interface{}, error) {
-		return breaker.lock.RunCritical(ID, func() (interface{}, error) {
-			return handle(ID, breaker)
-		})
+func (breaker *CircuitBreaker) handleSuccess(ID string, value interface{}, out schema.Outcome) (interface{}, error) {
+	transition, circuit, storeErr := breaker.store.recordSuccess(ID, out)
+
+	if out.Slow {
+		breaker.raiseSlowCall(ID)
+	}
+	if storeErr != nil && !(isLockFailure(storeErr) && breaker.proceedWithoutLock(ID, storeErr)) {
+		return nil, storeError(storeErr)
 	}
-}
 
-func (breaker *CircuitBreaker) tryReset(ID string) (bool, error) {
-	res, err := breaker.lock.RunCritical(ID, func() (interface{}, error) {
-		circuit, err := breaker.cache.Get(ID)
-		if err != nil {
-			return true, err
-		}
-		if circuit == nil {
-			return true, nil
-		}
+	breaker.emit(ID, transition, circuit, nil)
 
-		moveToHalfOpen := circuit.State == schema.Open &&
-			float64((time.Now().Sub(circuit.OpenedAt)).Nanoseconds())*float64(1e-06) > float64(breaker.gracePeriodMs)
+	return value, nil
+}
 
-		if moveToHalfOpen {
-			circuit.State = schema.HalfOpen
+// releaseProbe gives back a probe slot for a call which ended without an outcome
+func (breaker *CircuitBreaker) releaseProbe(ID string, out schema.Outcome) {
+	if !out.Probe {
+		return
+	}
 
-			breaker.cache.Set(ID, circuit)
+	err := breaker.store.update(ID, returnProbe)
+	if err != nil {
+		breaker.logError(fmt.Sprintf("Could not release probe for ID %s", ID), err)
+	}
+}
 
-			breaker.circuitChan <- circuitChan{ID, schema.HalfOpen}
-		}
+func isLockFailure(err error) bool {
+	return errors.Is(err, schema.ErrLockNotAcquired)
+}
 
-		return false, nil
-	})
-	return res.(bool), err
+// proceedWithoutLock whether a call carries on when its circuit's lock can't
+// be acquired, as set by the breaker's LockFailurePolicy
+func (breaker *CircuitBreaker) proceedWithoutLock(ID string, err error) bool {
+	breaker.logError(fmt.Sprintf("Could not lock ID %s", ID), err)
+	return breaker.lockFailurePolicy == FailOpen
 }
@@ -0,0 +1,150 @@
+package dcb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/danielglennross/go-dcb/cache"
+	"github.com/danielglennross/go-dcb/policies"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBreaker(t *testing.T, options ...CircuitBreakerOption) *CircuitBreaker {
+	c := cache.NewMemoryCache()
+
+	defaults := []CircuitBreakerOption{
+		TimeoutMs(50),
+		Retry(1),
+		BackoffMs(&policies.Fixed{WaitDuration: 10 * time.Millisecond}),
+	}
+
+	breaker, err := NewCircuitBreaker(c, c, append(defaults, options...)...)
+	require.NoError(t, err)
+
+	t.Cleanup(breaker.Destroy)
+	return breaker
+}
+
+func TestFireContextReturnsResult(t *testing.T) {
+	breaker := newTestBreaker(t)
+
+	res, err := breaker.FireContext(context.Background(), "id", func(ctx context.Context) (interface{}, error) {
+		return 5, nil
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, 5, res)
+}
+
+func TestRetryBelowOneStillRunsCall(t *testing.T) {
+	breaker := newTestBreaker(t, Retry(0))
+
+	ran := false
+	res, err := breaker.Fire("id", func() (interface{}, error) {
+		ran = true
+		return 1, nil
+	})
+	require.NoError(t, err)
+	require.True(t, ran)
+	require.Equal(t, 1, res)
+
+	stats, err := breaker.Stats("id")
+	require.NoError(t, err)
+	require.Equal(t, 0, stats.Failures)
+}
+
+func TestFireContextCancelsAttemptOnTimeout(t *testing.T) {
+	breaker := newTestBreaker(t)
+
+	cancelled := make(chan struct{})
+	_, err := breaker.FireContext(context.Background(), "id", func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	})
+
+	require.True(t, errors.Is(err, ErrTimeout))
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("attempt context was not cancelled")
+	}
+}
+
+func TestFireContextCancelsEachRetriedAttempt(t *testing.T) {
+	breaker := newTestBreaker(t, Retry(3))
+
+	attempts := make(chan struct{}, 3)
+	_, err := breaker.FireContext(context.Background(), "id", func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		attempts <- struct{}{}
+		return nil, ctx.Err()
+	})
+
+	require.Error(t, err)
+	for i := 0; i < 3; i++ {
+		select {
+		case <-attempts:
+		case <-time.After(time.Second):
+			t.Fatalf("attempt %d context was not cancelled", i)
+		}
+	}
+}
+
+func TestFireContextRespectsCallerDeadlineAcrossBackoff(t *testing.T) {
+	breaker := newTestBreaker(t,
+		Retry(5),
+		BackoffMs(&policies.Fixed{WaitDuration: time.Second}),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	calls := 0
+	start := time.Now()
+	_, err := breaker.FireContext(ctx, "id", func(ctx context.Context) (interface{}, error) {
+		calls++
+		return nil, fmt.Errorf("boom")
+	})
+
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.Equal(t, 1, calls)
+	require.True(t, time.Since(start) < time.Second)
+}
+
+func TestFireContextDoesNotRunWhenCallerDone(t *testing.T) {
+	breaker := newTestBreaker(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := breaker.FireContext(ctx, "id", func(ctx context.Context) (interface{}, error) {
+		t.Fatal("fn should not run")
+		return nil, nil
+	})
+
+	require.Equal(t, context.Canceled, err)
+}
+
+func TestDynamicFireContextPassesAttemptContext(t *testing.T) {
+	c := cache.NewMemoryCache()
+
+	fn := func(ctx context.Context, name string) (string, error) {
+		if ctx == nil {
+			return "", fmt.Errorf("no context")
+		}
+		return name, nil
+	}
+
+	breaker, err := NewCircuitBreakerDynamic(fn, c, c, TimeoutMs(50), Retry(1))
+	require.NoError(t, err)
+	defer breaker.Destroy()
+
+	res, err := breaker.FireContext(context.Background(), "id", "daniel")
+	require.NoError(t, err)
+	require.Equal(t, "daniel", res)
+}
@@ -0,0 +1,106 @@
+package dcb
+
+import (
+	"context"
+	"fmt"
+)
+
+// CloseStores have Close also close the breaker's cache, lock & notifier
+// (releasing any Redis clients they own). Only use it when they aren't
+// shared with another breaker, outside of a Registry (which closes them once)
+func CloseStores() CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.closeStores = true
+	}
+}
+
+type closer interface {
+	Close() error
+}
+
+type stopper interface {
+	Close()
+}
+
+// enter counts a call in, unless the breaker is closed
+func (breaker *CircuitBreaker) enter() bool {
+	breaker.closeMutex.RLock()
+	defer breaker.closeMutex.RUnlock()
+
+	if breaker.closed {
+		return false
+	}
+	breaker.inflight.Add(1)
+	return true
+}
+
+// Close stops the breaker accepting calls (they return ErrBreakerClosed), then
+// waits, until ctx is done, for in-flight calls & attempts and the events they
+// raised to be handled (& transitions published). Its notifier is unsubscribed,
+// and with CloseStores, its cache, lock & notifier closed. ctx's error is
+// returned if it's done first
+func (breaker *CircuitBreaker) Close(ctx context.Context) error {
+	breaker.closeMutex.Lock()
+	breaker.closed = true
+	breaker.closeMutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		breaker.inflight.Wait()
+		close(drained)
+	}()
+
+	err := waitFor(ctx, drained)
+
+	breaker.closeOnce.Do(func() {
+		if breaker.unsubscribe != nil {
+			if err := breaker.unsubscribe(); err != nil {
+				breaker.logError("Could not unsubscribe from notifier", err)
+			}
+		}
+		breaker.dispatcher.close()
+		if breaker.publisher != nil {
+			breaker.publisher.close()
+		}
+	})
+
+	if err == nil {
+		err = waitFor(ctx, breaker.dispatcher.done)
+	}
+	if err == nil && breaker.publisher != nil {
+		err = waitFor(ctx, breaker.publisher.done)
+	}
+
+	if breaker.closeStores {
+		breaker.storesOnce.Do(func() {
+			breaker.closeStore("cache", breaker.cache)
+			// a cache is often its own lock
+			if interface{}(breaker.lock) != interface{}(breaker.cache) {
+				breaker.closeStore("lock", breaker.lock)
+			}
+			breaker.closeStore("notifier", breaker.notifier)
+		})
+	}
+
+	return err
+}
+
+func (breaker *CircuitBreaker) closeStore(name string, store interface{}) {
+	switch s := store.(type) {
+	case closer:
+		if err := s.Close(); err != nil {
+			breaker.logError(fmt.Sprintf("Could not close %s", name), err)
+		}
+	case stopper:
+		s.Close()
+	}
+}
+
+func waitFor(ctx context.Context, done chan struct{}) error {
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,90 @@
+package dcb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/danielglennross/go-dcb/cache"
+	"github.com/stretchr/testify/require"
+)
+
+type closingCache struct {
+	*cache.MemoryCache
+	closes int
+}
+
+func (c *closingCache) Close() error {
+	c.closes++
+	return nil
+}
+
+func TestFireAfterCloseIsRejected(t *testing.T) {
+	breaker := newTestBreaker(t)
+	require.NoError(t, breaker.Close(context.Background()))
+
+	_, err := breaker.Fire("id", func() (interface{}, error) {
+		t.Fatal("fn should not run")
+		return nil, nil
+	})
+	require.True(t, errors.Is(err, ErrBreakerClosed))
+}
+
+func TestCloseWaitsForInflightAttempts(t *testing.T) {
+	breaker := newTestBreaker(t, TimeoutMs(1000))
+
+	started, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		_, _ = breaker.Fire("id", func() (interface{}, error) {
+			close(started)
+			<-release
+			return true, nil
+		})
+	}()
+	<-started
+
+	closed := make(chan error)
+	go func() { closed <- breaker.Close(context.Background()) }()
+
+	select {
+	case <-closed:
+		t.Fatal("Close should wait for the in-flight call")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-closed)
+}
+
+func TestCloseReturnsContextErrorWhenDeadlinePasses(t *testing.T) {
+	breaker := newTestBreaker(t, TimeoutMs(1000))
+
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+
+	go func() {
+		_, _ = breaker.Fire("id", func() (interface{}, error) {
+			close(started)
+			<-release
+			return true, nil
+		})
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	require.Equal(t, context.DeadlineExceeded, breaker.Close(ctx))
+}
+
+func TestCloseStoresClosesCacheOnce(t *testing.T) {
+	c := &closingCache{MemoryCache: cache.NewMemoryCache()}
+
+	breaker, err := NewCircuitBreaker(c, c, CloseStores())
+	require.NoError(t, err)
+
+	require.NoError(t, breaker.Close(context.Background()))
+	require.NoError(t, breaker.Close(context.Background()))
+	require.Equal(t, 1, c.closes)
+}
@@ -0,0 +1,139 @@
+package dcb
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy what raising an event does when the event queue is full
+type OverflowPolicy int
+
+const (
+	// DropNewest drop the event being raised
+	DropNewest OverflowPolicy = iota
+	// Block wait up to EventBlockTimeoutMs for room, then drop the event being raised
+	Block
+	// DropOldest drop the oldest queued events to make room
+	DropOldest
+)
+
+// EventQueueSize events queued for handlers before the OverflowPolicy applies (default 256)
+func EventQueueSize(size int) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.eventQueueSize = size
+	}
+}
+
+// EventOverflow policy for when the event queue is full (default DropNewest)
+func EventOverflow(policy OverflowPolicy) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.eventOverflow = policy
+	}
+}
+
+// EventBlockTimeoutMs longest an event is held waiting for room, with the Block policy (default 100)
+func EventBlockTimeoutMs(ms int64) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.eventBlockTimeoutMs = ms
+	}
+}
+
+// dispatcher queues events for a single goroutine delivering them to
+// handlers, so a slow handler can't stall a call
+type dispatcher struct {
+	mutex        sync.RWMutex // guards closed, held while sending to queue
+	closed       bool
+	queue        chan Event
+	policy       OverflowPolicy
+	blockTimeout time.Duration
+	dropped      uint64
+	done         chan struct{}
+}
+
+func newDispatcher(size int, policy OverflowPolicy, blockTimeout time.Duration) *dispatcher {
+	if size < 1 {
+		size = 1
+	}
+	return &dispatcher{
+		queue:        make(chan Event, size),
+		policy:       policy,
+		blockTimeout: blockTimeout,
+		done:         make(chan struct{}),
+	}
+}
+
+// run delivers events until the dispatcher is closed & its queue drained
+func (d *dispatcher) run(deliver func(event Event)) {
+	defer close(d.done)
+
+	for event := range d.queue {
+		deliver(event)
+	}
+}
+
+// dispatch queues an event, applying the overflow policy if the queue is
+// full. Events dispatched once closed are dropped
+func (d *dispatcher) dispatch(event Event) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if d.closed {
+		d.drop()
+		return
+	}
+
+	select {
+	case d.queue <- event:
+		return
+	default:
+	}
+
+	switch d.policy {
+	case Block:
+		timer := time.NewTimer(d.blockTimeout)
+		defer timer.Stop()
+
+		select {
+		case d.queue <- event:
+		case <-timer.C:
+			d.drop()
+		}
+	case DropOldest:
+		for {
+			select {
+			case d.queue <- event:
+				return
+			default:
+			}
+			select {
+			case <-d.queue:
+				d.drop()
+			default:
+			}
+		}
+	default:
+		d.drop()
+	}
+}
+
+func (d *dispatcher) drop() {
+	atomic.AddUint64(&d.dropped, 1)
+}
+
+// close stops queueing events, queued events are still delivered
+func (d *dispatcher) close() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.closed {
+		return
+	}
+	d.closed = true
+	close(d.queue)
+}
+
+// DroppedEvents events dropped because the queue was full, or the breaker destroyed
+func (breaker *CircuitBreaker) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&breaker.dispatcher.dropped)
+}
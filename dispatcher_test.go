@@ -0,0 +1,65 @@
+package dcb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlowHandlerDoesNotStallFire(t *testing.T) {
+	breaker := newTestBreaker(t, EventQueueSize(1))
+
+	release := make(chan struct{})
+	defer close(release)
+	breaker.OnFallback(func(e Event) { <-release })
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		_, err := breaker.Fire("id", func() (interface{}, error) {
+			return nil, fmt.Errorf("boom")
+		})
+		require.Error(t, err)
+	}
+
+	require.True(t, time.Since(start) < time.Second)
+	require.True(t, breaker.DroppedEvents() > 0)
+}
+
+func TestDispatcherDropOldestKeepsNewest(t *testing.T) {
+	d := newDispatcher(2, DropOldest, 0)
+
+	for i := 0; i < 5; i++ {
+		d.dispatch(Event{ID: fmt.Sprintf("%d", i)})
+	}
+	d.close()
+
+	IDs := []string{}
+	d.run(func(event Event) { IDs = append(IDs, event.ID) })
+
+	require.Equal(t, []string{"3", "4"}, IDs)
+	require.Equal(t, uint64(3), d.dropped)
+}
+
+func TestDispatcherBlockTimesOut(t *testing.T) {
+	d := newDispatcher(1, Block, 20*time.Millisecond)
+
+	d.dispatch(Event{ID: "queued"})
+
+	start := time.Now()
+	d.dispatch(Event{ID: "dropped"})
+
+	require.True(t, time.Since(start) >= 20*time.Millisecond)
+	require.Equal(t, uint64(1), d.dropped)
+}
+
+func TestEventsAfterDestroyAreDropped(t *testing.T) {
+	breaker := newTestBreaker(t)
+	breaker.Destroy()
+
+	require.NotPanics(t, func() {
+		breaker.Isolate("id")
+	})
+	require.Equal(t, uint64(2), breaker.DroppedEvents())
+}
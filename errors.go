@@ -0,0 +1,111 @@
+package dcb
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrCircuitOpen returned (as a *RejectedError) when a call is rejected by an
+// open circuit, it also matches calls rejected by an isolated circuit
+var ErrCircuitOpen = errors.New("circuit open")
+
+// ErrCircuitIsolated returned (as a *RejectedError) when a call is rejected by
+// an isolated circuit, or an isolated ancestor (see Hierarchy)
+var ErrCircuitIsolated = errors.New("circuit isolated")
+
+// ErrHalfOpenProbeLimit returned (as a *RejectedError) when a half open circuit
+// is already running its permitted number of probe calls
+var ErrHalfOpenProbeLimit = errors.New("half open probe limit reached")
+
+// ErrTimeout returned when an attempt runs past the breaker's timeout
+var ErrTimeout = errors.New("A timeout occurred")
+
+// ErrPanic recorded as a call's failure when its fn panics
+var ErrPanic = errors.New("A panic occurred")
+
+// ErrRetriesExhausted returned (as a *RetriesExhaustedError) when every
+// attempt of a retried call fails
+var ErrRetriesExhausted = errors.New("retries exhausted")
+
+// ErrLockUnavailable returned, wrapping the lock's error, when a circuit's
+// lock can't be acquired and the LockFailurePolicy is FailClosed
+var ErrLockUnavailable = errors.New("lock unavailable")
+
+// ErrStore returned, wrapping the store's error, when a circuit can't be read or written
+var ErrStore = errors.New("circuit store failed")
+
+// ErrUnmanagedCache returned managing circuits when the breaker's cache
+// isn't a schema.ManagedCache
+var ErrUnmanagedCache = errors.New("cache can't list, delete or expire circuits")
+
+// ErrNoHierarchy returned by group operations when the breaker has no Hierarchy
+var ErrNoHierarchy = errors.New("breaker has no hierarchy")
+
+// ErrBreakerClosed returned by calls to a closed breaker
+var ErrBreakerClosed = errors.New("breaker closed")
+
+// RejectedError a call rejected without running
+type RejectedError struct {
+	ID string
+	// Reason ErrCircuitOpen, ErrCircuitIsolated or ErrHalfOpenProbeLimit
+	Reason error
+	// Ancestor the isolated ancestor which rejected the call, if any
+	Ancestor string
+	// RetryAfter the open circuit's remaining grace period, 0 otherwise
+	RetryAfter time.Duration
+}
+
+func (e *RejectedError) Error() string {
+	if e.Ancestor != "" {
+		return fmt.Sprintf("%v for ID: %s, %s is isolated", e.Reason, e.ID, e.Ancestor)
+	}
+	return fmt.Sprintf("%v for ID: %s", e.Reason, e.ID)
+}
+
+// Is an isolated circuit is held open, so is ErrCircuitOpen too
+func (e *RejectedError) Is(target error) bool {
+	return target == ErrCircuitOpen && e.Reason == ErrCircuitIsolated
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Reason
+}
+
+// RetriesExhaustedError returned when every attempt of a call fails, however
+// many attempts were made
+type RetriesExhaustedError struct {
+	ID string
+	// Errs each attempt's error, in order
+	Errs []error
+}
+
+func (e *RetriesExhaustedError) Error() string {
+	if len(e.Errs) == 0 {
+		return fmt.Sprintf("%v for ID: %s", ErrRetriesExhausted, e.ID)
+	}
+	attempts := "attempts"
+	if len(e.Errs) == 1 {
+		attempts = "attempt"
+	}
+	return fmt.Sprintf("%v for ID: %s after %d %s: %v", ErrRetriesExhausted, e.ID, len(e.Errs), attempts, e.Errs[len(e.Errs)-1])
+}
+
+// Is matches ErrRetriesExhausted
+func (e *RetriesExhaustedError) Is(target error) bool {
+	return target == ErrRetriesExhausted
+}
+
+// Unwrap every attempt's error, so errors.Is & errors.As see each of them
+func (e *RetriesExhaustedError) Unwrap() []error {
+	return e.Errs
+}
+
+// storeError wraps an error reading or writing a circuit, as ErrLockUnavailable
+// if its lock couldn't be acquired, otherwise ErrStore
+func storeError(err error) error {
+	if isLockFailure(err) {
+		return fmt.Errorf("%w: %w", ErrLockUnavailable, err)
+	}
+	return fmt.Errorf("%w: %w", ErrStore, err)
+}
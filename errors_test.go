@@ -0,0 +1,87 @@
+package dcb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/danielglennross/go-dcb/cache"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetriesExhaustedWrapsEveryAttempt(t *testing.T) {
+	breaker := newTestBreaker(t, Retry(3), Threshold(10))
+
+	first := errors.New("first")
+	attempt := 0
+
+	_, err := breaker.Fire("id", func() (interface{}, error) {
+		attempt++
+		if attempt == 1 {
+			return nil, first
+		}
+		return nil, fmt.Errorf("attempt %d", attempt)
+	})
+
+	var exhausted *RetriesExhaustedError
+	require.True(t, errors.As(err, &exhausted))
+	require.True(t, errors.Is(err, ErrRetriesExhausted))
+	require.True(t, errors.Is(err, first))
+	require.Len(t, exhausted.Errs, 3)
+	require.EqualError(t, err, "retries exhausted for ID: id after 3 attempts: attempt 3")
+}
+
+func TestRetriesExhaustedWithoutErrs(t *testing.T) {
+	err := &RetriesExhaustedError{ID: "id"}
+	require.EqualError(t, err, "retries exhausted for ID: id")
+}
+
+func TestOpenRejectionCarriesRemainingGracePeriod(t *testing.T) {
+	breaker := newTestBreaker(t, GracePeriodMs(60000))
+
+	fail := func() (interface{}, error) { return nil, fmt.Errorf("boom") }
+	_, _ = breaker.Fire("id", fail)
+	_, _ = breaker.Fire("id", fail)
+
+	_, err := breaker.Fire("id", fail)
+
+	var rejected *RejectedError
+	require.True(t, errors.As(err, &rejected))
+	require.True(t, errors.Is(err, ErrCircuitOpen))
+	require.False(t, errors.Is(err, ErrCircuitIsolated))
+	require.True(t, rejected.RetryAfter > 59*time.Second && rejected.RetryAfter <= 60*time.Second)
+	require.EqualError(t, err, "circuit open for ID: id")
+}
+
+func TestIsolatedRejectionIsAlsoOpen(t *testing.T) {
+	breaker := newTestBreaker(t)
+	require.True(t, breaker.Isolate("id"))
+
+	_, err := breaker.Fire("id", func() (interface{}, error) { return true, nil })
+	require.True(t, errors.Is(err, ErrCircuitIsolated))
+	require.True(t, errors.Is(err, ErrCircuitOpen))
+}
+
+func TestLockUnavailableWhenFailClosed(t *testing.T) {
+	breaker, err := NewCircuitBreaker(cache.NewMemoryCache(), unavailableLock{}, LockFailure(FailClosed))
+	require.NoError(t, err)
+	defer breaker.Destroy()
+
+	_, err = breaker.Fire("id", func() (interface{}, error) { return true, nil })
+	require.True(t, errors.Is(err, ErrLockUnavailable))
+	require.False(t, errors.Is(err, ErrStore))
+}
+
+func TestSingleFailedAttemptIsRetriesExhausted(t *testing.T) {
+	breaker := newTestBreaker(t, Retry(1))
+
+	boom := errors.New("boom")
+	_, err := breaker.Fire("id", func() (interface{}, error) {
+		return nil, boom
+	})
+
+	require.True(t, errors.Is(err, ErrRetriesExhausted))
+	require.True(t, errors.Is(err, boom))
+	require.EqualError(t, err, "retries exhausted for ID: id after 1 attempt: boom")
+}
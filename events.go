@@ -0,0 +1,176 @@
+package dcb
+
+import (
+	"errors"
+	"time"
+
+	"github.com/danielglennross/go-dcb/schema"
+)
+
+// EventKind what an Event reports
+type EventKind int
+
+const (
+	// TransitionEvent a circuit changed state
+	TransitionEvent EventKind = iota
+	// FallbackEvent a call was rejected, or failed
+	FallbackEvent
+	// SlowCallEvent a call was slow
+	SlowCallEvent
+)
+
+// Event a circuit breaker event
+type Event struct {
+	Kind EventKind
+	ID   string
+	// From & To states of a TransitionEvent
+	From, To schema.State
+	// Cause the error of a FallbackEvent, or the failure which opened a circuit
+	Cause error
+	// Failures, Calls, FailedCalls & SlowCalls the circuit's counts after a
+	// TransitionEvent, window counts are only kept with a sliding window
+	Failures    int
+	Calls       int
+	FailedCalls int
+	SlowCalls   int
+	Timestamp   time.Time
+	// Node which raised the event, Remote if that's another node
+	Node   string
+	Remote bool
+}
+
+// EventHandler circuit breaker event delegate
+type EventHandler func(event Event)
+
+// listenerKind the listeners an event is delivered to
+type listenerKind int
+
+const (
+	closedListener listenerKind = iota
+	openListener
+	halfOpenListener
+	transitionListener
+	fallbackListener
+	slowCallListener
+)
+
+// OnClosed handle on closed (or force closed)
+func (breaker *CircuitBreaker) OnClosed(closed EventHandler) *CircuitBreaker {
+	return breaker.listen(closedListener, closed)
+}
+
+// OnOpen handle on opened (or isolated)
+func (breaker *CircuitBreaker) OnOpen(open EventHandler) *CircuitBreaker {
+	return breaker.listen(openListener, open)
+}
+
+// OnHalfOpen handle on half opened
+func (breaker *CircuitBreaker) OnHalfOpen(halfOpen EventHandler) *CircuitBreaker {
+	return breaker.listen(halfOpenListener, halfOpen)
+}
+
+// OnTransition handle every state change
+func (breaker *CircuitBreaker) OnTransition(transition EventHandler) *CircuitBreaker {
+	return breaker.listen(transitionListener, transition)
+}
+
+// OnSlowCall handle slow call
+func (breaker *CircuitBreaker) OnSlowCall(slowCall EventHandler) *CircuitBreaker {
+	return breaker.listen(slowCallListener, slowCall)
+}
+
+// OnFallback handle fallack
+func (breaker *CircuitBreaker) OnFallback(fallback EventHandler) *CircuitBreaker {
+	return breaker.listen(fallbackListener, fallback)
+}
+
+// listen adds a handler, alongside any already added
+func (breaker *CircuitBreaker) listen(kind listenerKind, handler EventHandler) *CircuitBreaker {
+	breaker.listenersMutex.Lock()
+	defer breaker.listenersMutex.Unlock()
+
+	breaker.listeners[kind] = append(breaker.listeners[kind], handler)
+	return breaker
+}
+
+// deliver calls the handlers listening for an event
+func (breaker *CircuitBreaker) deliver(event Event) {
+	var kinds []listenerKind
+	switch event.Kind {
+	case FallbackEvent:
+		kinds = []listenerKind{fallbackListener}
+	case SlowCallEvent:
+		kinds = []listenerKind{slowCallListener}
+	case TransitionEvent:
+		kinds = []listenerKind{transitionListener}
+		switch event.To {
+		case schema.Closed, schema.ForceClosed:
+			kinds = append(kinds, closedListener)
+		case schema.Open, schema.Isolate:
+			kinds = append(kinds, openListener)
+		case schema.HalfOpen:
+			kinds = append(kinds, halfOpenListener)
+		}
+	}
+
+	breaker.listenersMutex.RLock()
+	handlers := []EventHandler{}
+	for _, kind := range kinds {
+		handlers = append(handlers, breaker.listeners[kind]...)
+	}
+	breaker.listenersMutex.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// raise queues an event for delivery, stamped by this node
+func (breaker *CircuitBreaker) raise(event Event) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	if event.Node == "" {
+		event.Node = breaker.node
+	}
+	breaker.dispatcher.dispatch(event)
+}
+
+func (breaker *CircuitBreaker) raiseFallback(ID string, cause error) {
+	breaker.raise(Event{Kind: FallbackEvent, ID: ID, Cause: cause})
+}
+
+func (breaker *CircuitBreaker) raiseSlowCall(ID string) {
+	breaker.raise(Event{Kind: SlowCallEvent, ID: ID})
+}
+
+// emit raises a transition this node made, with the circuit the store left
+// behind, and publishes it to other nodes (outside the event queue, so it's
+// published even if the event is dropped)
+func (breaker *CircuitBreaker) emit(ID string, transition *schema.Transition, circuit *schema.Circuit, cause error) {
+	if transition == nil {
+		return
+	}
+
+	event := Event{Kind: TransitionEvent, ID: ID, From: transition.From, To: transition.To, Cause: cause, Timestamp: time.Now()}
+	if circuit != nil {
+		totals := breaker.totals(&circuit.Window, event.Timestamp)
+
+		event.Failures = circuit.Failures
+		event.Calls = totals.calls
+		event.FailedCalls = totals.failures
+		event.SlowCalls = totals.slowCalls
+	}
+	if breaker.publisher != nil {
+		breaker.publisher.push(event)
+	}
+	breaker.raise(event)
+}
+
+// causeOf the error of a published cause
+func causeOf(cause string) error {
+	if cause == "" {
+		return nil
+	}
+	return errors.New(cause)
+}
@@ -0,0 +1,97 @@
+package dcb
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danielglennross/go-dcb/cache"
+	"github.com/danielglennross/go-dcb/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func receive(t *testing.T, events chan Event) Event {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("event was not raised")
+		return Event{}
+	}
+}
+
+func TestEventsCarryTransitionAndCause(t *testing.T) {
+	breaker := newTestBreaker(t, NodeID("node-1"))
+
+	first := make(chan Event, 1)
+	second := make(chan Event, 1)
+	transitions := make(chan Event, 1)
+	fallbacks := make(chan Event, 2)
+
+	breaker.OnOpen(func(e Event) { first <- e })
+	breaker.OnOpen(func(e Event) { second <- e })
+	breaker.OnTransition(func(e Event) { transitions <- e })
+	breaker.OnFallback(func(e Event) { fallbacks <- e })
+
+	for i := 0; i < 2; i++ {
+		_, err := breaker.Fire("id", func() (interface{}, error) {
+			return nil, fmt.Errorf("boom")
+		})
+		require.EqualError(t, err, "retries exhausted for ID: id after 1 attempt: boom")
+	}
+
+	for _, events := range []chan Event{first, second, transitions} {
+		event := receive(t, events)
+		require.Equal(t, TransitionEvent, event.Kind)
+		require.Equal(t, "id", event.ID)
+		require.Equal(t, schema.Closed, event.From)
+		require.Equal(t, schema.Open, event.To)
+		require.EqualError(t, event.Cause, "retries exhausted for ID: id after 1 attempt: boom")
+		require.Equal(t, 2, event.Failures)
+		require.Equal(t, "node-1", event.Node)
+		require.False(t, event.Remote)
+		require.False(t, event.Timestamp.IsZero())
+	}
+
+	for i := 0; i < 2; i++ {
+		event := receive(t, fallbacks)
+		require.Equal(t, FallbackEvent, event.Kind)
+		require.EqualError(t, event.Cause, "retries exhausted for ID: id after 1 attempt: boom")
+	}
+}
+
+// countingLock counts the critical sections run through it
+type countingLock struct {
+	*cache.MemoryCache
+	runs int32
+}
+
+func (l *countingLock) RunCritical(ID string, fn func() (interface{}, error)) (interface{}, error) {
+	atomic.AddInt32(&l.runs, 1)
+	return l.MemoryCache.RunCritical(ID, fn)
+}
+
+func TestTransitionEventsDontReadCircuitAgain(t *testing.T) {
+	lock := &countingLock{MemoryCache: cache.NewMemoryCache()}
+	require.NoError(t, lock.Set("id", &schema.Circuit{State: schema.Closed}))
+
+	breaker, err := NewCircuitBreaker(lock.MemoryCache, lock, Threshold(1), Retry(1))
+	require.NoError(t, err)
+	defer breaker.Destroy()
+
+	opened := make(chan Event, 1)
+	breaker.OnOpen(func(e Event) { opened <- e })
+
+	fail := func() int32 {
+		before := atomic.LoadInt32(&lock.runs)
+		_, _ = breaker.Fire("id", func() (interface{}, error) {
+			return nil, fmt.Errorf("boom")
+		})
+		return atomic.LoadInt32(&lock.runs) - before
+	}
+
+	counted := fail()
+	require.Equal(t, counted, fail())
+	require.Equal(t, 2, receive(t, opened).Failures)
+}
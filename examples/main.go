@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"time"
 
+	dcb "github.com/danielglennross/go-dcb"
 	c "github.com/danielglennross/go-dcb/cache"
 	"github.com/danielglennross/go-dcb/policies"
 )
@@ -45,28 +47,28 @@ func main() {
 		policies.Factor(2),
 	)
 
-	dynamicBreaker, err := NewCircuitBreakerDynamic(
+	dynamicBreaker, err := dcb.NewCircuitBreakerDynamic(
 		fn,
 		cache,
 		lock,
-		FailCondition(func(err error) bool {
+		dcb.FailCondition(func(err error) bool {
 			return err != nil
 		}),
-		GracePeriodMs(500),
-		Threshold(1),
-		TimeoutMs(1000),
-		BackoffMs(backoff),
-		Retry(3),
+		dcb.GracePeriodMs(500),
+		dcb.Threshold(1),
+		dcb.TimeoutMs(1000),
+		dcb.BackoffMs(backoff),
+		dcb.Retry(3),
 	)
 
 	if err != nil {
 		fmt.Println(err)
 	}
 
-	dynamicBreaker.OnClosed(func(ID string) { fmt.Printf("%s", ID) })
-	dynamicBreaker.OnFallback(func(ID string) { fmt.Printf("%s", ID) })
-	dynamicBreaker.OnOpen(func(ID string) { fmt.Printf("%s", ID) })
-	dynamicBreaker.OnHalfOpen(func(ID string) { fmt.Printf("%s", ID) })
+	dynamicBreaker.OnClosed(func(e dcb.Event) { fmt.Printf("%s", e.ID) })
+	dynamicBreaker.OnFallback(func(e dcb.Event) { fmt.Printf("%s", e.ID) })
+	dynamicBreaker.OnOpen(func(e dcb.Event) { fmt.Printf("%s", e.ID) })
+	dynamicBreaker.OnHalfOpen(func(e dcb.Event) { fmt.Printf("%s", e.ID) })
 
 	res1, err := dynamicBreaker.Fire("myFn", "daniel", 2)
 	if err != nil {
@@ -81,27 +83,27 @@ func main() {
 
 	// ... //
 
-	staticBreaker, err := NewCircuitBreaker(
+	staticBreaker, err := dcb.NewCircuitBreaker(
 		cache,
 		lock,
-		FailCondition(func(err error) bool {
+		dcb.FailCondition(func(err error) bool {
 			return err != nil
 		}),
-		GracePeriodMs(500),
-		Threshold(1),
-		TimeoutMs(1000),
-		BackoffMs(backoff),
-		Retry(3),
+		dcb.GracePeriodMs(500),
+		dcb.Threshold(1),
+		dcb.TimeoutMs(1000),
+		dcb.BackoffMs(backoff),
+		dcb.Retry(3),
 	)
 
 	if err != nil {
 		fmt.Println(err)
 	}
 
-	staticBreaker.OnClosed(func(ID string) { fmt.Printf("%s", ID) })
-	staticBreaker.OnFallback(func(ID string) { fmt.Printf("%s", ID) })
-	staticBreaker.OnOpen(func(ID string) { fmt.Printf("%s", ID) })
-	staticBreaker.OnHalfOpen(func(ID string) { fmt.Printf("%s", ID) })
+	staticBreaker.OnClosed(func(e dcb.Event) { fmt.Printf("%s", e.ID) })
+	staticBreaker.OnFallback(func(e dcb.Event) { fmt.Printf("%s", e.ID) })
+	staticBreaker.OnOpen(func(e dcb.Event) { fmt.Printf("%s", e.ID) })
+	staticBreaker.OnHalfOpen(func(e dcb.Event) { fmt.Printf("%s", e.ID) })
 
 	res2, err := staticBreaker.Fire("myFn", func() (interface{}, error) {
 		fmt.Println("Hello World")
@@ -114,6 +116,19 @@ func main() {
 		fmt.Printf("%v", res2.(int))
 	}
 
+	typedBreaker := dcb.Typed[int](staticBreaker)
+
+	res3, err := typedBreaker.Fire(context.Background(), "myFn", func(ctx context.Context) (int, error) {
+		fmt.Println("Hello Typed World")
+		return 15, nil
+	})
+
+	if err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Printf("%d", res3)
+	}
+
 	_ = staticBreaker.Isolate("myFn")
 	_ = staticBreaker.Reset("myFn")
 	staticBreaker.Destroy()
@@ -0,0 +1,64 @@
+package dcb
+
+import (
+	"context"
+	"fmt"
+)
+
+// FallbackFn runs in place of a call which was rejected (its circuit open or
+// isolated, or over its half open probe limit) or failed every attempt, it's
+// passed the call's error. Its result is returned by Fire
+type FallbackFn func(ctx context.Context, err error) (interface{}, error)
+
+// FallbackError returned by Fire when a call and its fallback both fail
+type FallbackError struct {
+	// Err the call's error
+	Err error
+	// FallbackErr the fallback's error
+	FallbackErr error
+}
+
+func (e *FallbackError) Error() string {
+	return fmt.Sprintf("fallback failed: %v, after: %v", e.FallbackErr, e.Err)
+}
+
+// Unwrap the call's error, so errors.Is & errors.As see why the call failed
+func (e *FallbackError) Unwrap() error {
+	return e.Err
+}
+
+// Fallback fallback run for every call which is rejected or fails, unless
+// the call has its own (see FireWithFallback)
+func Fallback(fn FallbackFn) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.fallback = fn
+	}
+}
+
+// FireWithFallback fire the static breaker, running fallback (in place of the
+// breaker's) if the call is rejected or fails
+func (breaker *CircuitBreaker) FireWithFallback(ID string, fn CircuitBreakerFn, fallback FallbackFn) (interface{}, error) {
+	return breaker.FireContextWithFallback(context.Background(), ID, func(ctx context.Context) (interface{}, error) {
+		return fn()
+	}, fallback)
+}
+
+// FireContextWithFallback fire the static breaker with a context, running
+// fallback (in place of the breaker's) if the call is rejected or fails
+func (breaker *CircuitBreaker) FireContextWithFallback(ctx context.Context, ID string, fn CircuitBreakerContextFn, fallback FallbackFn) (interface{}, error) {
+	return breaker.fire(ctx, ID, fn, fallback)
+}
+
+// runFallback runs fallback for a call which failed with err, err is
+// returned as is if there's no fallback
+func runFallback(ctx context.Context, err error, fallback FallbackFn) (interface{}, error) {
+	if fallback == nil {
+		return nil, err
+	}
+
+	res, fallbackErr := fallback(ctx, err)
+	if fallbackErr != nil {
+		return nil, &FallbackError{Err: err, FallbackErr: fallbackErr}
+	}
+	return res, nil
+}
@@ -0,0 +1,84 @@
+package dcb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFallbackResultReturnedWhenRetriesFail(t *testing.T) {
+	breaker := newTestBreaker(t, Fallback(func(ctx context.Context, err error) (interface{}, error) {
+		return "cached", nil
+	}))
+
+	res, err := breaker.Fire("id", func() (interface{}, error) {
+		return nil, fmt.Errorf("boom")
+	})
+	require.NoError(t, err)
+	require.Equal(t, "cached", res)
+}
+
+func TestFallbackRunsWhenCircuitIsolated(t *testing.T) {
+	breaker := newTestBreaker(t)
+	require.True(t, breaker.Isolate("id"))
+
+	var cause error
+	res, err := breaker.FireWithFallback("id", func() (interface{}, error) {
+		t.Fatal("fn should not run")
+		return nil, nil
+	}, func(ctx context.Context, err error) (interface{}, error) {
+		cause = err
+		return 5, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 5, res)
+	require.True(t, errors.Is(cause, ErrCircuitIsolated))
+}
+
+func TestCallFallbackReplacesBreakerFallback(t *testing.T) {
+	breaker := newTestBreaker(t, Fallback(func(ctx context.Context, err error) (interface{}, error) {
+		return "breaker", nil
+	}))
+
+	res, err := breaker.FireWithFallback("id", func() (interface{}, error) {
+		return nil, fmt.Errorf("boom")
+	}, func(ctx context.Context, err error) (interface{}, error) {
+		return "call", nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "call", res)
+}
+
+func TestFailingFallbackReportsBothErrors(t *testing.T) {
+	primary := errors.New("boom")
+
+	breaker := newTestBreaker(t, Fallback(func(ctx context.Context, err error) (interface{}, error) {
+		return nil, fmt.Errorf("no cached value")
+	}))
+
+	_, err := breaker.Fire("id", func() (interface{}, error) {
+		return nil, primary
+	})
+
+	var fallbackErr *FallbackError
+	require.True(t, errors.As(err, &fallbackErr))
+	require.True(t, errors.Is(fallbackErr.Err, ErrRetriesExhausted))
+	require.EqualError(t, fallbackErr.FallbackErr, "no cached value")
+	require.True(t, errors.Is(err, primary))
+}
+
+func TestFallbackNotRunForSuccess(t *testing.T) {
+	breaker := newTestBreaker(t)
+
+	res, err := FireWithFallback(context.Background(), breaker, "id", func(ctx context.Context) (int, error) {
+		return 1, nil
+	}, func(ctx context.Context, err error) (int, error) {
+		t.Fatal("fallback should not run")
+		return 0, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+}
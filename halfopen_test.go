@@ -0,0 +1,127 @@
+package dcb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/danielglennross/go-dcb/policies"
+	"github.com/danielglennross/go-dcb/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func tripCircuit(t *testing.T, breaker *CircuitBreaker, ID string) {
+	_, err := breaker.Fire(ID, func() (interface{}, error) {
+		return nil, fmt.Errorf("boom")
+	})
+	require.EqualError(t, err, fmt.Sprintf("retries exhausted for ID: %s after 1 attempt: boom", ID))
+
+	stats, err := breaker.Stats(ID)
+	require.NoError(t, err)
+	require.Equal(t, schema.Open, stats.State)
+}
+
+func TestHalfOpenRejectsCallsOverProbeLimit(t *testing.T) {
+	breaker := newTestBreaker(t,
+		Threshold(0),
+		GracePeriodMs(10),
+		TimeoutMs(1000),
+		HalfOpenMaxProbes(1),
+	)
+
+	fallbacks := make(chan string, 1)
+	breaker.OnFallback(func(e Event) { fallbacks <- e.ID })
+
+	tripCircuit(t, breaker, "id")
+	<-fallbacks
+	time.Sleep(20 * time.Millisecond)
+
+	running := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+
+	go func() {
+		_, err := breaker.FireContext(context.Background(), "id", func(ctx context.Context) (interface{}, error) {
+			close(running)
+			<-release
+			return true, nil
+		})
+		done <- err
+	}()
+	<-running
+
+	_, err := breaker.Fire("id", func() (interface{}, error) {
+		t.Fatal("probe limit exceeded")
+		return nil, nil
+	})
+	require.True(t, errors.Is(err, ErrHalfOpenProbeLimit))
+	require.Equal(t, "id", <-fallbacks)
+
+	close(release)
+	require.NoError(t, <-done)
+
+	stats, err := breaker.Stats("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Closed, stats.State)
+}
+
+func TestHalfOpenNeedsSuccessThresholdToClose(t *testing.T) {
+	breaker := newTestBreaker(t,
+		Threshold(0),
+		GracePeriodMs(10),
+		HalfOpenSuccessThreshold(2),
+	)
+
+	tripCircuit(t, breaker, "id")
+	time.Sleep(20 * time.Millisecond)
+
+	succeed := func() (interface{}, error) { return true, nil }
+
+	_, err := breaker.Fire("id", succeed)
+	require.NoError(t, err)
+
+	stats, err := breaker.Stats("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.HalfOpen, stats.State)
+
+	_, err = breaker.Fire("id", succeed)
+	require.NoError(t, err)
+
+	stats, err = breaker.Stats("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Closed, stats.State)
+}
+
+func TestHalfOpenFailureReopens(t *testing.T) {
+	breaker := newTestBreaker(t,
+		GracePeriodMs(10),
+		SlidingWindowCount(1),
+		MinimumCalls(1),
+	)
+
+	tripCircuit(t, breaker, "id")
+	time.Sleep(20 * time.Millisecond)
+
+	_, err := breaker.Fire("id", func() (interface{}, error) {
+		return nil, fmt.Errorf("boom")
+	})
+	require.EqualError(t, err, "retries exhausted for ID: id after 1 attempt: boom")
+
+	stats, err := breaker.Stats("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Open, stats.State)
+}
+
+func TestProbeLeaseCoversRetryBackoff(t *testing.T) {
+	breaker := newTestBreaker(t,
+		TimeoutMs(50),
+		Retry(3),
+		BackoffMs(&policies.Fixed{WaitDuration: time.Second}),
+		GracePeriodMs(500),
+	)
+
+	// 3 attempts timing out, 2 backoffs between them, then the grace period
+	require.Equal(t, 150*time.Millisecond+2*time.Second+500*time.Millisecond, breaker.probeLease())
+}
@@ -0,0 +1,77 @@
+package dcb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/danielglennross/go-dcb/schema"
+)
+
+// Hierarchy treat IDs as paths split by separator (for example "billing.invoices"),
+// so a call is rejected while any ancestor circuit ("billing") is isolated.
+// Each call then also reads its ancestors' circuits
+func Hierarchy(separator string) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.separator = separator
+	}
+}
+
+// ancestors an ID's ancestors, outermost first
+func (breaker *CircuitBreaker) ancestors(ID string) []string {
+	if breaker.separator == "" {
+		return nil
+	}
+
+	parts := strings.Split(ID, breaker.separator)
+	ancestors := make([]string, 0, len(parts)-1)
+	for i := 1; i < len(parts); i++ {
+		ancestors = append(ancestors, strings.Join(parts[:i], breaker.separator))
+	}
+	return ancestors
+}
+
+// isolatedAncestor the first of an ID's ancestors which is isolated, if any
+func (breaker *CircuitBreaker) isolatedAncestor(ID string) (string, error) {
+	for _, ancestor := range breaker.ancestors(ID) {
+		circuit, err := breaker.store.get(ancestor)
+		if err != nil {
+			return "", err
+		}
+		if circuit != nil && circuit.State == schema.Isolate {
+			return ancestor, nil
+		}
+	}
+	return "", nil
+}
+
+// IsolateGroup isolates a group's circuit & every descendant circuit in the
+// store, returning the number isolated
+func (breaker *CircuitBreaker) IsolateGroup(group string) (int, error) {
+	return breaker.eachInGroup(group, breaker.Isolate)
+}
+
+// ResetGroup resets a group's circuit & every descendant circuit in the
+// store to closed, returning the number reset
+func (breaker *CircuitBreaker) ResetGroup(group string) (int, error) {
+	return breaker.eachInGroup(group, breaker.Reset)
+}
+
+func (breaker *CircuitBreaker) eachInGroup(group string, fn func(ID string) bool) (int, error) {
+	if breaker.separator == "" {
+		return 0, ErrNoHierarchy
+	}
+
+	descendants, err := breaker.List(group + breaker.separator)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, ID := range append([]string{group}, descendants...) {
+		if !fn(ID) {
+			return n, fmt.Errorf("Could not update ID %s of group %s", ID, group)
+		}
+		n++
+	}
+	return n, nil
+}
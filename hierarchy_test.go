@@ -0,0 +1,85 @@
+package dcb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/danielglennross/go-dcb/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsolatedAncestorRejectsChildren(t *testing.T) {
+	breaker := newTestBreaker(t, Hierarchy("."))
+
+	ok := func() (interface{}, error) { return true, nil }
+
+	require.True(t, breaker.Isolate("billing"))
+
+	_, err := breaker.Fire("billing.invoices.get", ok)
+	require.EqualError(t, err, "circuit isolated for ID: billing.invoices.get, billing is isolated")
+
+	res, err := breaker.Fire("billingreport", ok)
+	require.NoError(t, err)
+	require.Equal(t, true, res)
+
+	require.True(t, breaker.Reset("billing"))
+
+	res, err = breaker.Fire("billing.invoices.get", ok)
+	require.NoError(t, err)
+	require.Equal(t, true, res)
+}
+
+func TestIsolatedAncestorRejectsPinnedChildren(t *testing.T) {
+	breaker := newTestBreaker(t, Hierarchy("."))
+
+	require.True(t, breaker.ForceClose("billing.invoices"))
+	require.True(t, breaker.Disable("billing.payments"))
+	require.True(t, breaker.Isolate("billing"))
+
+	for _, ID := range []string{"billing.invoices", "billing.payments"} {
+		_, err := breaker.Fire(ID, func() (interface{}, error) {
+			t.Fatal("fn should not run")
+			return nil, nil
+		})
+		require.EqualError(t, err, fmt.Sprintf("circuit isolated for ID: %s, billing is isolated", ID))
+	}
+}
+
+func TestIsolateAndResetGroup(t *testing.T) {
+	breaker := newTestBreaker(t, Hierarchy("."))
+
+	for _, ID := range []string{"billing.invoices", "billing.payments", "search"} {
+		_, err := breaker.Fire(ID, func() (interface{}, error) {
+			return nil, fmt.Errorf("boom")
+		})
+		require.Error(t, err)
+	}
+
+	n, err := breaker.IsolateGroup("billing")
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	for _, ID := range []string{"billing", "billing.invoices", "billing.payments"} {
+		stats, err := breaker.Stats(ID)
+		require.NoError(t, err)
+		require.Equal(t, schema.Isolate, stats.State)
+	}
+	search, err := breaker.Stats("search")
+	require.NoError(t, err)
+	require.Equal(t, schema.Closed, search.State)
+
+	n, err = breaker.ResetGroup("billing")
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	stats, err := breaker.Stats("billing.payments")
+	require.NoError(t, err)
+	require.Equal(t, schema.Closed, stats.State)
+}
+
+func TestGroupsRequireHierarchy(t *testing.T) {
+	breaker := newTestBreaker(t)
+
+	_, err := breaker.IsolateGroup("billing")
+	require.Equal(t, ErrNoHierarchy, err)
+}
@@ -0,0 +1,34 @@
+package dcb
+
+import (
+	"time"
+
+	"github.com/danielglennross/go-dcb/schema"
+)
+
+// List lists the IDs of circuits starting with prefix
+func (breaker *CircuitBreaker) List(prefix string) ([]string, error) {
+	mc, ok := breaker.cache.(schema.ManagedCache)
+	if !ok {
+		return nil, ErrUnmanagedCache
+	}
+	return mc.List(prefix)
+}
+
+// Delete deletes a circuit, it's created closed again on its next call
+func (breaker *CircuitBreaker) Delete(ID string) error {
+	mc, ok := breaker.cache.(schema.ManagedCache)
+	if !ok {
+		return ErrUnmanagedCache
+	}
+	return mc.Delete(ID)
+}
+
+// Expire expires a circuit after ttl (<= 0 never), until it's next written
+func (breaker *CircuitBreaker) Expire(ID string, ttl time.Duration) error {
+	mc, ok := breaker.cache.(schema.ManagedCache)
+	if !ok {
+		return ErrUnmanagedCache
+	}
+	return mc.Expire(ID, ttl)
+}
@@ -0,0 +1,47 @@
+package dcb
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/danielglennross/go-dcb/cache"
+	"github.com/danielglennross/go-dcb/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManageCircuits(t *testing.T) {
+	breaker := newTestBreaker(t)
+
+	for _, ID := range []string{"billing.invoices", "billing.payments", "search"} {
+		_, err := breaker.Fire(ID, func() (interface{}, error) {
+			return nil, fmt.Errorf("boom")
+		})
+		require.Error(t, err)
+	}
+
+	IDs, err := breaker.List("billing.")
+	require.NoError(t, err)
+	sort.Strings(IDs)
+	require.Equal(t, []string{"billing.invoices", "billing.payments"}, IDs)
+
+	require.NoError(t, breaker.Delete("billing.invoices"))
+	require.NoError(t, breaker.Expire("search", time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+
+	IDs, err = breaker.List("")
+	require.NoError(t, err)
+	require.Equal(t, []string{"billing.payments"}, IDs)
+}
+
+func TestManageCircuitsRequiresManagedCache(t *testing.T) {
+	type plainCache struct{ schema.VersionedCache }
+
+	breaker, err := NewCircuitBreaker(plainCache{cache.NewMemoryCache()}, nil)
+	require.NoError(t, err)
+	defer breaker.Destroy()
+
+	_, err = breaker.List("")
+	require.Equal(t, ErrUnmanagedCache, err)
+}
@@ -0,0 +1,158 @@
+package dcb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/danielglennross/go-dcb/schema"
+)
+
+// Notifier publish this node's transitions, and raise other nodes' transitions
+// as local events (dropping any local copies of their circuits)
+func Notifier(n schema.Notifier) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.notifier = n
+	}
+}
+
+// NodeID identifies this node in published transitions (default random)
+func NodeID(node string) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.node = node
+	}
+}
+
+func newNodeID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "node"
+	}
+	return hex.EncodeToString(b)
+}
+
+func (breaker *CircuitBreaker) subscribe() error {
+	if breaker.notifier == nil {
+		return nil
+	}
+
+	unsubscribe, err := breaker.notifier.Subscribe(breaker.receive)
+	if err != nil {
+		return err
+	}
+	breaker.unsubscribe = unsubscribe
+	return nil
+}
+
+// publisher publishes this node's transitions from a single goroutine, in
+// order. Unlike the event queue its queue is unbounded, so none are dropped
+type publisher struct {
+	mutex   sync.Mutex // guards queue & closed
+	ready   *sync.Cond
+	queue   []Event
+	closed  bool
+	publish func(event Event)
+	done    chan struct{}
+}
+
+func newPublisher(publish func(event Event)) *publisher {
+	p := &publisher{publish: publish, done: make(chan struct{})}
+	p.ready = sync.NewCond(&p.mutex)
+	return p
+}
+
+// run publishes transitions until the publisher is closed & its queue drained
+func (p *publisher) run() {
+	defer close(p.done)
+
+	for {
+		p.mutex.Lock()
+		for len(p.queue) == 0 && !p.closed {
+			p.ready.Wait()
+		}
+		if len(p.queue) == 0 {
+			p.mutex.Unlock()
+			return
+		}
+		event := p.queue[0]
+		p.queue = p.queue[1:]
+		p.mutex.Unlock()
+
+		p.publish(event)
+	}
+}
+
+// push queues a transition, once closed it's published in place
+func (p *publisher) push(event Event) {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		p.publish(event)
+		return
+	}
+	p.queue = append(p.queue, event)
+	p.mutex.Unlock()
+
+	p.ready.Signal()
+}
+
+// close stops queueing transitions, queued transitions are still published
+func (p *publisher) close() {
+	p.mutex.Lock()
+	p.closed = true
+	p.mutex.Unlock()
+
+	p.ready.Broadcast()
+}
+
+// publish tells other nodes of a transition this node made
+func (breaker *CircuitBreaker) publish(event Event) {
+	if breaker.notifier == nil {
+		return
+	}
+
+	notification := schema.Notification{
+		ID:          event.ID,
+		Node:        breaker.node,
+		Transition:  schema.Transition{From: event.From, To: event.To},
+		Failures:    event.Failures,
+		Calls:       event.Calls,
+		FailedCalls: event.FailedCalls,
+		SlowCalls:   event.SlowCalls,
+		At:          event.Timestamp,
+	}
+	if event.Cause != nil {
+		notification.Cause = event.Cause.Error()
+	}
+
+	if err := breaker.notifier.Publish(notification); err != nil {
+		breaker.logError(fmt.Sprintf("Could not publish transition for ID %s", event.ID), err)
+	}
+}
+
+// receive raises another node's transition as a local event
+func (breaker *CircuitBreaker) receive(notification schema.Notification) {
+	if notification.Node == breaker.node {
+		return
+	}
+
+	if inv, ok := breaker.cache.(schema.Invalidator); ok {
+		inv.Invalidate(notification.ID)
+	}
+
+	breaker.raise(Event{
+		Kind:        TransitionEvent,
+		ID:          notification.ID,
+		From:        notification.From,
+		To:          notification.To,
+		Cause:       causeOf(notification.Cause),
+		Failures:    notification.Failures,
+		Calls:       notification.Calls,
+		FailedCalls: notification.FailedCalls,
+		SlowCalls:   notification.SlowCalls,
+		Timestamp:   notification.At,
+		Node:        notification.Node,
+		Remote:      true,
+	})
+}
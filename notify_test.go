@@ -0,0 +1,186 @@
+package dcb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danielglennross/go-dcb/cache"
+	"github.com/danielglennross/go-dcb/schema"
+	"github.com/stretchr/testify/require"
+)
+
+// localNotifier delivers notifications to every subscriber in process
+type localNotifier struct {
+	mutex       sync.Mutex
+	subscribers map[int]func(schema.Notification)
+	next        int
+}
+
+func (n *localNotifier) Publish(notification schema.Notification) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for _, fn := range n.subscribers {
+		go fn(notification)
+	}
+	return nil
+}
+
+func (n *localNotifier) Subscribe(fn func(schema.Notification)) (func() error, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.subscribers == nil {
+		n.subscribers = map[int]func(schema.Notification){}
+	}
+	n.next++
+	id := n.next
+	n.subscribers[id] = fn
+
+	return func() error {
+		n.mutex.Lock()
+		defer n.mutex.Unlock()
+		delete(n.subscribers, id)
+		return nil
+	}, nil
+}
+
+type invalidatingCache struct {
+	*cache.MemoryCache
+	invalidated chan string
+}
+
+func (c invalidatingCache) Invalidate(ID string) {
+	c.invalidated <- ID
+}
+
+func TestNotifierRaisesRemoteTransitions(t *testing.T) {
+	notifier := &localNotifier{}
+	shared := cache.NewMemoryCache()
+
+	local, err := NewCircuitBreaker(shared, shared, Retry(1), Notifier(notifier), NodeID("local"))
+	require.NoError(t, err)
+	defer local.Destroy()
+
+	remoteCache := invalidatingCache{shared, make(chan string, 1)}
+	remote, err := NewCircuitBreaker(remoteCache, shared, Notifier(notifier), NodeID("remote"))
+	require.NoError(t, err)
+	defer remote.Destroy()
+
+	localOpened := make(chan string, 1)
+	local.OnOpen(func(e Event) { localOpened <- e.ID })
+	remoteOpened := make(chan string, 1)
+	remote.OnOpen(func(e Event) {
+		if e.Remote && e.Node == "local" {
+			remoteOpened <- e.ID
+		}
+	})
+
+	for i := 0; i < 2; i++ {
+		_, _ = local.Fire("id", func() (interface{}, error) {
+			return nil, fmt.Errorf("boom")
+		})
+	}
+
+	for _, opened := range []chan string{localOpened, remoteOpened, remoteCache.invalidated} {
+		select {
+		case ID := <-opened:
+			require.Equal(t, "id", ID)
+		case <-time.After(time.Second):
+			t.Fatal("transition was not raised")
+		}
+	}
+
+	// a node doesn't raise its own transitions twice
+	select {
+	case <-localOpened:
+		t.Fatal("local transition raised twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// blockingNotifier holds each publish until released
+type blockingNotifier struct {
+	localNotifier
+	release   chan struct{}
+	published chan schema.Notification
+}
+
+func (n *blockingNotifier) Publish(notification schema.Notification) error {
+	<-n.release
+	n.published <- notification
+	return nil
+}
+
+func TestSlowNotifierDoesntStallCalls(t *testing.T) {
+	notifier := &blockingNotifier{release: make(chan struct{}), published: make(chan schema.Notification, 1)}
+	shared := cache.NewMemoryCache()
+
+	breaker, err := NewCircuitBreaker(shared, shared, Threshold(0), Retry(1), Notifier(notifier))
+	require.NoError(t, err)
+	defer breaker.Destroy()
+
+	done := make(chan struct{})
+	go func() {
+		_, _ = breaker.Fire("id", func() (interface{}, error) {
+			return nil, fmt.Errorf("boom")
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("call waited on the notifier")
+	}
+
+	close(notifier.release)
+	notification := <-notifier.published
+	require.Equal(t, schema.Open, notification.To)
+	require.Equal(t, 1, notification.Failures)
+}
+
+// recordingNotifier records every notification published
+type recordingNotifier struct {
+	localNotifier
+	published chan schema.Notification
+}
+
+func (n *recordingNotifier) Publish(notification schema.Notification) error {
+	n.published <- notification
+	return nil
+}
+
+func TestTransitionsArePublishedWhenEventsAreDropped(t *testing.T) {
+	notifier := &recordingNotifier{published: make(chan schema.Notification, 10)}
+	shared := cache.NewMemoryCache()
+
+	breaker, err := NewCircuitBreaker(shared, shared, Threshold(0), Retry(1), Notifier(notifier), EventQueueSize(1))
+	require.NoError(t, err)
+
+	release := make(chan struct{})
+	breaker.OnOpen(func(e Event) { <-release })
+
+	for i := 0; i < 5; i++ {
+		_, _ = breaker.Fire(fmt.Sprintf("id-%d", i), func() (interface{}, error) {
+			return nil, fmt.Errorf("boom")
+		})
+	}
+	require.NotZero(t, breaker.DroppedEvents())
+
+	for i := 0; i < 5; i++ {
+		select {
+		case notification := <-notifier.published:
+			require.Equal(t, fmt.Sprintf("id-%d", i), notification.ID)
+			require.Equal(t, schema.Open, notification.To)
+		case <-time.After(time.Second):
+			t.Fatal("transition was not published")
+		}
+	}
+
+	close(release)
+	require.NoError(t, breaker.Close(context.Background()))
+}
@@ -1,3 +1,4 @@
+// Package policies provides retry backoff policies.
 package policies
 
 import (
@@ -13,6 +14,12 @@ type Backoff interface {
 	Duration() time.Duration
 }
 
+// Bounded a backoff whose durations never exceed MaxDuration, breakers use it to
+// work out how long a call (with its retries) can run
+type Bounded interface {
+	MaxDuration() time.Duration
+}
+
 // Exponential backoff
 type Exponential struct {
 	min, max time.Duration
@@ -20,31 +27,32 @@ type Exponential struct {
 	attempt  int
 }
 
-type exponentialOption func(*Exponential)
+// ExponentialOption exponential backoff option
+type ExponentialOption func(*Exponential)
 
 // Min duration in milliseconds
-func Min(min time.Duration) exponentialOption {
+func Min(min time.Duration) ExponentialOption {
 	return func(e *Exponential) {
 		e.min = min
 	}
 }
 
 // Max duration in milliseconds
-func Max(max time.Duration) exponentialOption {
+func Max(max time.Duration) ExponentialOption {
 	return func(e *Exponential) {
 		e.max = max
 	}
 }
 
 // Factor exponent
-func Factor(factor float64) exponentialOption {
+func Factor(factor float64) ExponentialOption {
 	return func(e *Exponential) {
 		e.factor = factor
 	}
 }
 
 // NewExponential Exponential ctor
-func NewExponential(options ...exponentialOption) (*Exponential, error) {
+func NewExponential(options ...ExponentialOption) (*Exponential, error) {
 	e := &Exponential{
 		min:     100 * time.Millisecond,
 		max:     10 * 1000 * time.Millisecond,
@@ -91,6 +99,11 @@ func (b *Exponential) Duration() time.Duration {
 	return dur
 }
 
+// MaxDuration the longest exponential duration
+func (b *Exponential) MaxDuration() time.Duration {
+	return b.max
+}
+
 // Fixed default
 type Fixed struct {
 	WaitDuration time.Duration
@@ -104,3 +117,8 @@ func (b *Fixed) Duration() time.Duration {
 
 	return b.WaitDuration
 }
+
+// MaxDuration Fixed
+func (b *Fixed) MaxDuration() time.Duration {
+	return b.Duration()
+}
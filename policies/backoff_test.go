@@ -38,11 +38,11 @@ func TestFixedDurationReturnsConsistentValue(t *testing.T) {
 }
 
 func TestExponentialReturnsErrorIfMinGreaterThanMax(t *testing.T) {
-	_, err := NewExponential(&ExponentialOptions{
-		Min:    100 * time.Millisecond,
-		Max:    10 * time.Millisecond,
-		Factor: 2,
-	})
+	_, err := NewExponential(
+		Min(100*time.Millisecond),
+		Max(10*time.Millisecond),
+		Factor(2),
+	)
 
 	require.EqualError(t, err, "Min: 100ms cannot be greater than Max: 10ms")
 }
@@ -50,7 +50,7 @@ func TestExponentialReturnsErrorIfMinGreaterThanMax(t *testing.T) {
 func TestExponentialReturnsDefaultValue(t *testing.T) {
 	defaultMin := 100 * time.Millisecond
 
-	e, _ := NewExponential(nil)
+	e, _ := NewExponential()
 
 	v := e.Duration()
 	require.Equal(t, v, defaultMin)
@@ -59,22 +59,22 @@ func TestExponentialReturnsDefaultValue(t *testing.T) {
 func TestExponentialReturnsMinValue(t *testing.T) {
 	min := 200 * time.Millisecond
 
-	e, _ := NewExponential(&ExponentialOptions{
-		Min:    min,
-		Max:    10 * time.Second,
-		Factor: 2,
-	})
+	e, _ := NewExponential(
+		Min(min),
+		Max(10*time.Second),
+		Factor(2),
+	)
 
 	v := e.Duration()
 	require.Equal(t, v, min)
 }
 
 func TestExponentialReturnsIncreasingValue(t *testing.T) {
-	e, _ := NewExponential(&ExponentialOptions{
-		Min:    200 * time.Millisecond,
-		Max:    10 * time.Second,
-		Factor: 2,
-	})
+	e, _ := NewExponential(
+		Min(200*time.Millisecond),
+		Max(10*time.Second),
+		Factor(2),
+	)
 
 	v := e.Duration()
 	require.Equal(t, v, 200*time.Millisecond)
@@ -90,11 +90,11 @@ func TestExponentialReturnsIncreasingValue(t *testing.T) {
 }
 
 func TestExponentialDoesNotExceedMax(t *testing.T) {
-	e, _ := NewExponential(&ExponentialOptions{
-		Min:    200 * time.Millisecond,
-		Max:    400 * time.Millisecond,
-		Factor: 2,
-	})
+	e, _ := NewExponential(
+		Min(200*time.Millisecond),
+		Max(400*time.Millisecond),
+		Factor(2),
+	)
 
 	v := e.Duration()
 	require.Equal(t, v, 200*time.Millisecond)
@@ -105,3 +105,12 @@ func TestExponentialDoesNotExceedMax(t *testing.T) {
 	v = e.Duration()
 	require.Equal(t, v, 400*time.Millisecond)
 }
+
+func TestMaxDurationIsLongestDuration(t *testing.T) {
+	e, err := NewExponential(Min(10*time.Millisecond), Max(40*time.Millisecond))
+	require.NoError(t, err)
+	require.Equal(t, 40*time.Millisecond, e.MaxDuration())
+
+	f := &Fixed{}
+	require.Equal(t, f.Duration(), f.MaxDuration())
+}
@@ -0,0 +1,263 @@
+package dcb
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/danielglennross/go-dcb/schema"
+)
+
+// Registry creates & reuses breakers per ID, from default options and the
+// overrides matching the ID. IDs matching the same overrides share a breaker,
+// and every breaker shares the registry's NodeID
+type Registry struct {
+	cache     schema.Cache
+	lock      schema.DistLock
+	node      string
+	defaults  []CircuitBreakerOption
+	overrides []override
+	onBreaker func(key string, breaker *CircuitBreaker)
+	maxKnown  int
+
+	mutex    sync.Mutex
+	closed   bool
+	breakers map[string]*CircuitBreaker
+	known    map[string]*CircuitBreaker
+	// closing the breakers created with CloseStores, whose stores the
+	// registry closes (once, as they're shared)
+	closing []*CircuitBreaker
+}
+
+type override struct {
+	pattern string
+	options []CircuitBreakerOption
+}
+
+// RegistryOption registry option
+type RegistryOption func(*Registry)
+
+// Defaults options of every breaker
+func Defaults(options ...CircuitBreakerOption) RegistryOption {
+	return func(r *Registry) {
+		r.defaults = append(r.defaults, options...)
+	}
+}
+
+// Override options of the breakers of IDs matching pattern (path.Match syntax,
+// so an ID matches itself), applied over the defaults & earlier overrides
+func Override(pattern string, options ...CircuitBreakerOption) RegistryOption {
+	return func(r *Registry) {
+		r.overrides = append(r.overrides, override{pattern, options})
+	}
+}
+
+// OnBreaker called with each breaker the registry creates, to handle its events
+func OnBreaker(fn func(key string, breaker *CircuitBreaker)) RegistryOption {
+	return func(r *Registry) {
+		r.onBreaker = fn
+	}
+}
+
+// MaxKnownIDs how many IDs the registry remembers (default 10000, < 1
+// unbounded), past which arbitrary ones are forgotten (& resolved to their
+// breaker again on next use)
+func MaxKnownIDs(n int) RegistryOption {
+	return func(r *Registry) {
+		r.maxKnown = n
+	}
+}
+
+// NewRegistry ctor
+func NewRegistry(cache schema.Cache, lock schema.DistLock, options ...RegistryOption) (*Registry, error) {
+	r := new(Registry)
+	r.cache = cache
+	r.lock = lock
+	r.breakers = make(map[string]*CircuitBreaker)
+	r.known = make(map[string]*CircuitBreaker)
+	r.onBreaker = func(key string, breaker *CircuitBreaker) {}
+	r.maxKnown = 10000
+	r.node = newNodeID()
+
+	for _, opt := range options {
+		opt(r)
+	}
+
+	for _, o := range r.overrides {
+		if _, err := path.Match(o.pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid override pattern %s: %w", o.pattern, err)
+		}
+	}
+
+	return r, nil
+}
+
+// Breaker the breaker for ID, created on first use. Once the registry is
+// closed, ErrBreakerClosed is returned
+func (r *Registry) Breaker(ID string) (*CircuitBreaker, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return nil, ErrBreakerClosed
+	}
+
+	if breaker, ok := r.known[ID]; ok {
+		return breaker, nil
+	}
+
+	options := append([]CircuitBreakerOption{NodeID(r.node)}, r.defaults...)
+	matched := []string{}
+	for i, o := range r.overrides {
+		if ok, _ := path.Match(o.pattern, ID); ok {
+			options = append(options, o.options...)
+			matched = append(matched, strconv.Itoa(i))
+		}
+	}
+	key := strings.Join(matched, ",")
+
+	breaker, ok := r.breakers[key]
+	if !ok {
+		var err error
+		breaker, err = NewCircuitBreaker(r.cache, r.lock, options...)
+		if err != nil {
+			return nil, err
+		}
+		if breaker.closeStores {
+			breaker.closeStores = false
+			r.closing = append(r.closing, breaker)
+		}
+		r.breakers[key] = breaker
+		r.onBreaker(key, breaker)
+	}
+
+	r.remember(ID, breaker)
+	return breaker, nil
+}
+
+// remember keeps ID's breaker, forgetting others if there are already maxKnown
+func (r *Registry) remember(ID string, breaker *CircuitBreaker) {
+	if r.maxKnown > 0 && len(r.known) >= r.maxKnown {
+		for key := range r.known {
+			if len(r.known) < r.maxKnown {
+				break
+			}
+			delete(r.known, key)
+		}
+	}
+	r.known[ID] = breaker
+}
+
+// Fire fires ID's breaker
+func (r *Registry) Fire(ID string, fn CircuitBreakerFn) (interface{}, error) {
+	breaker, err := r.Breaker(ID)
+	if err != nil {
+		return nil, err
+	}
+	return breaker.Fire(ID, fn)
+}
+
+// FireContext fires ID's breaker with a context
+func (r *Registry) FireContext(ctx context.Context, ID string, fn CircuitBreakerContextFn) (interface{}, error) {
+	breaker, err := r.Breaker(ID)
+	if err != nil {
+		return nil, err
+	}
+	return breaker.FireContext(ctx, ID, fn)
+}
+
+// IDs the IDs of the circuits this registry has fired or managed (up to
+// MaxKnownIDs of them), sorted
+func (r *Registry) IDs() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	IDs := make([]string, 0, len(r.known))
+	for ID := range r.known {
+		IDs = append(IDs, ID)
+	}
+	sort.Strings(IDs)
+	return IDs
+}
+
+// Stats gets a circuit's statistics
+func (r *Registry) Stats(ID string) (Stats, error) {
+	breaker, err := r.Breaker(ID)
+	if err != nil {
+		return Stats{}, err
+	}
+	return breaker.Stats(ID)
+}
+
+// Inspect gets the statistics of every circuit this registry knows
+func (r *Registry) Inspect() ([]Stats, error) {
+	all := []Stats{}
+	for _, ID := range r.IDs() {
+		stats, err := r.Stats(ID)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, stats)
+	}
+	return all, nil
+}
+
+// Isolate manually open (and hold open) a circuit
+func (r *Registry) Isolate(ID string) bool {
+	breaker, err := r.Breaker(ID)
+	return err == nil && breaker.Isolate(ID)
+}
+
+// Reset resets a circuit to closed
+func (r *Registry) Reset(ID string) bool {
+	breaker, err := r.Breaker(ID)
+	return err == nil && breaker.Reset(ID)
+}
+
+// Close closes every breaker, waiting until ctx is done for their in-flight
+// calls, see CircuitBreaker.Close. The stores of breakers created with
+// CloseStores are then closed, each once. The first error is returned. No
+// breaker is created once the registry is closed
+func (r *Registry) Close(ctx context.Context) error {
+	r.mutex.Lock()
+	r.closed = true
+	breakers := make([]*CircuitBreaker, 0, len(r.breakers))
+	for _, breaker := range r.breakers {
+		breakers = append(breakers, breaker)
+	}
+	closing := r.closing
+	r.closing = nil
+	r.mutex.Unlock()
+
+	var err error
+	for _, breaker := range breakers {
+		if closeErr := breaker.Close(ctx); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	closed := map[interface{}]bool{nil: true}
+	for _, breaker := range closing {
+		for _, s := range []struct {
+			name  string
+			store interface{}
+		}{{"cache", breaker.cache}, {"lock", breaker.lock}, {"notifier", breaker.notifier}} {
+			if !closed[s.store] {
+				closed[s.store] = true
+				breaker.closeStore(s.name, s.store)
+			}
+		}
+	}
+	return err
+}
+
+// Destroy disposes of every breaker, without waiting for in-flight calls
+func (r *Registry) Destroy() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = r.Close(ctx)
+}
@@ -0,0 +1,158 @@
+package dcb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/danielglennross/go-dcb/cache"
+	"github.com/danielglennross/go-dcb/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryAppliesOverrides(t *testing.T) {
+	c := cache.NewMemoryCache()
+
+	registry, err := NewRegistry(c, c,
+		Defaults(TimeoutMs(50), Retry(1), Threshold(1)),
+		Override("payments.*", Threshold(5)),
+	)
+	require.NoError(t, err)
+	defer registry.Destroy()
+
+	fail := func() (interface{}, error) { return nil, fmt.Errorf("boom") }
+
+	for i := 0; i < 3; i++ {
+		_, _ = registry.Fire("search", fail)
+		_, _ = registry.Fire("payments.card", fail)
+	}
+
+	search, err := registry.Stats("search")
+	require.NoError(t, err)
+	require.Equal(t, schema.Open, search.State)
+
+	payments, err := registry.Stats("payments.card")
+	require.NoError(t, err)
+	require.Equal(t, schema.Closed, payments.State)
+
+	require.Equal(t, []string{"payments.card", "search"}, registry.IDs())
+}
+
+func TestRegistryReusesBreakers(t *testing.T) {
+	c := cache.NewMemoryCache()
+
+	registry, err := NewRegistry(c, c, Override("payments.*", Threshold(5)))
+	require.NoError(t, err)
+	defer registry.Destroy()
+
+	card, err := registry.Breaker("payments.card")
+	require.NoError(t, err)
+	bank, err := registry.Breaker("payments.bank")
+	require.NoError(t, err)
+	search, err := registry.Breaker("search")
+	require.NoError(t, err)
+
+	require.True(t, card == bank)
+	require.True(t, card != search)
+}
+
+func TestRegistryIsolatesAndResets(t *testing.T) {
+	c := cache.NewMemoryCache()
+
+	registry, err := NewRegistry(c, c)
+	require.NoError(t, err)
+	defer registry.Destroy()
+
+	require.True(t, registry.Isolate("search"))
+
+	stats, err := registry.Inspect()
+	require.NoError(t, err)
+	require.Len(t, stats, 1)
+	require.Equal(t, schema.Isolate, stats[0].State)
+
+	require.True(t, registry.Reset("search"))
+
+	stats, err = registry.Inspect()
+	require.NoError(t, err)
+	require.Equal(t, schema.Closed, stats[0].State)
+}
+
+func TestRegistryRejectsBadPattern(t *testing.T) {
+	c := cache.NewMemoryCache()
+
+	_, err := NewRegistry(c, c, Override("[", Threshold(5)))
+	require.Error(t, err)
+}
+
+func TestRegistryRejectsCallsOnceClosed(t *testing.T) {
+	c := cache.NewMemoryCache()
+
+	registry, err := NewRegistry(c, c)
+	require.NoError(t, err)
+
+	search, err := registry.Breaker("search")
+	require.NoError(t, err)
+	require.NoError(t, registry.Close(context.Background()))
+
+	for _, ID := range []string{"search", "payments"} {
+		_, err = registry.Breaker(ID)
+		require.True(t, errors.Is(err, ErrBreakerClosed))
+
+		_, err = registry.Fire(ID, func() (interface{}, error) {
+			t.Fatal("fn should not run")
+			return nil, nil
+		})
+		require.True(t, errors.Is(err, ErrBreakerClosed))
+	}
+
+	_, err = search.Fire("search", func() (interface{}, error) { return nil, nil })
+	require.True(t, errors.Is(err, ErrBreakerClosed))
+}
+
+func TestRegistryForgetsIDsPastMaxKnown(t *testing.T) {
+	c := cache.NewMemoryCache()
+
+	registry, err := NewRegistry(c, c, MaxKnownIDs(2))
+	require.NoError(t, err)
+	defer registry.Destroy()
+
+	for _, ID := range []string{"a", "b", "c"} {
+		_, err = registry.Fire(ID, func() (interface{}, error) { return true, nil })
+		require.NoError(t, err)
+	}
+
+	IDs := registry.IDs()
+	require.Len(t, IDs, 2)
+	require.Contains(t, IDs, "c")
+}
+
+func TestRegistryBreakersShareNodeID(t *testing.T) {
+	c := cache.NewMemoryCache()
+
+	registry, err := NewRegistry(c, c, Override("payments.*", Threshold(5)))
+	require.NoError(t, err)
+	defer registry.Destroy()
+
+	card, err := registry.Breaker("payments.card")
+	require.NoError(t, err)
+	search, err := registry.Breaker("search")
+	require.NoError(t, err)
+
+	require.True(t, card != search)
+	require.Equal(t, card.node, search.node)
+}
+
+func TestRegistryClosesSharedStoresOnce(t *testing.T) {
+	c := &closingCache{MemoryCache: cache.NewMemoryCache()}
+
+	registry, err := NewRegistry(c, c, Defaults(CloseStores()), Override("payments.*", Threshold(5)))
+	require.NoError(t, err)
+
+	for _, ID := range []string{"payments.card", "search"} {
+		_, err = registry.Breaker(ID)
+		require.NoError(t, err)
+	}
+	require.NoError(t, registry.Close(context.Background()))
+	require.Equal(t, 1, c.closes)
+}
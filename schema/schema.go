@@ -1,12 +1,22 @@
+// Package schema defines the circuit model and the cache & lock contracts.
 package schema
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 const (
 	Closed State = iota
 	Open
 	HalfOpen
 	Isolate
+	// ForceClosed held closed, calls are never rejected & failures not counted
+	ForceClosed
+	// Disabled metrics only, outcomes are counted & events raised, but the
+	// circuit never trips & calls are never rejected
+	Disabled
 )
 
 // State circuit state
@@ -17,6 +27,68 @@ type Circuit struct {
 	State    State
 	Failures int
 	OpenedAt time.Time
+	Window   Window
+
+	// Probes half open calls in flight, ProbeSuccesses since half opening
+	Probes         int
+	ProbeSuccesses int
+	ProbesExpireAt time.Time
+
+	// Version bumped by a VersionedCache on every write
+	Version int64
+
+	// SchemaVersion format the circuit was stored in, see Migrate
+	SchemaVersion int
+}
+
+// CurrentSchemaVersion format circuits are stored in, bump it (adding a
+// migration) when a stored circuit needs more than its new fields zeroed
+const CurrentSchemaVersion = 1
+
+// migrations migrations[i] moves a circuit stored in version i to i+1
+var migrations = []func(circuit *Circuit){
+	// 0: stored before versioning, new fields are zero
+	func(circuit *Circuit) {},
+}
+
+// ErrSchemaVersion returned reading a circuit stored in a newer format than this build knows
+var ErrSchemaVersion = errors.New("unknown circuit schema version")
+
+// Migrate moves a stored circuit forward to CurrentSchemaVersion
+func Migrate(circuit *Circuit) error {
+	if circuit.SchemaVersion > CurrentSchemaVersion {
+		return fmt.Errorf("%w %d", ErrSchemaVersion, circuit.SchemaVersion)
+	}
+	for ; circuit.SchemaVersion < CurrentSchemaVersion; circuit.SchemaVersion++ {
+		migrations[circuit.SchemaVersion](circuit)
+	}
+	return nil
+}
+
+// Clone deep copies a circuit
+func (circuit *Circuit) Clone() *Circuit {
+	if circuit == nil {
+		return nil
+	}
+	c := *circuit
+	c.Window.Buckets = append([]Bucket(nil), circuit.Window.Buckets...)
+	return &c
+}
+
+// Window sliding window of call outcomes, held as a ring of buckets
+type Window struct {
+	// Seq number of calls recorded, used to index count based windows
+	Seq     int64
+	Buckets []Bucket
+}
+
+// Bucket outcomes recorded for a window epoch, a call sequence number
+// for count based windows or a time slot for time based windows
+type Bucket struct {
+	Epoch     int64
+	Calls     int
+	Failures  int
+	SlowCalls int
 }
 
 // Cache cache contract
@@ -25,10 +97,160 @@ type Cache interface {
 	Set(ID string, circuit *Circuit) error
 }
 
+// VersionedCache a cache supporting optimistic updates, so no DistLock is needed
+type VersionedCache interface {
+	Cache
+	// GetVersioned gets a circuit & its version (0 if it doesn't exist)
+	GetVersioned(ID string) (*Circuit, int64, error)
+	// CompareAndSet sets a circuit only if its version is still expectedVersion
+	CompareAndSet(ID string, circuit *Circuit, expectedVersion int64) (bool, error)
+}
+
+// LayeredCache a cache serving reads from a local copy of another cache's
+// circuits, which may be stale
+type LayeredCache interface {
+	Cache
+	// GetLatest gets a circuit from the cache behind the local copy
+	GetLatest(ID string) (*Circuit, error)
+}
+
+// ManagedCache a cache whose circuits can be listed, deleted & expired
+type ManagedCache interface {
+	Cache
+	// List lists the IDs of circuits starting with prefix
+	List(prefix string) ([]string, error)
+	Delete(ID string) error
+	// Expire expires a circuit after ttl (<= 0 never), until it's next set
+	Expire(ID string, ttl time.Duration) error
+}
+
+// TransitionStore a cache which runs circuit transitions atomically itself
+// (for example as server side scripts), so no DistLock is needed
+type TransitionStore interface {
+	Cache
+	// CheckState gets a circuit, creating it closed if it doesn't exist
+	CheckState(ID string) (*Circuit, error)
+	// TryHalfOpen moves an open circuit past its grace period to half open,
+	// and admits (or rejects) a call against the circuit's probe limit.
+	// It & the Record methods return the circuit after a transition, nil
+	// without one
+	TryHalfOpen(ID string, policy Policy, now time.Time) (Admission, *Transition, *Circuit, error)
+	RecordSuccess(ID string, policy Policy, outcome Outcome, now time.Time) (*Transition, *Circuit, error)
+	RecordFailure(ID string, policy Policy, outcome Outcome, now time.Time) (*Transition, *Circuit, error)
+	// Update applies fn to a circuit atomically, fn may be run more than once
+	Update(ID string, fn func(circuit *Circuit)) error
+}
+
+// ErrLockNotAcquired returned by a DistLock when it can't acquire its lock,
+// in which case the critical section is not run
+var ErrLockNotAcquired = errors.New("lock not acquired")
+
+// ErrLockLost returned by a DistLock when it couldn't hold its lock for the
+// whole critical section
+var ErrLockLost = errors.New("lock lost")
+
+// ErrStaleFence returned by a FencedCache when a write carries a fencing token
+// older than the last one it accepted
+var ErrStaleFence = errors.New("stale fencing token")
+
 // DistLock distributed lock
 type DistLock interface {
 	RunCritical(ID string, fn func() (interface{}, error)) (interface{}, error)
 }
 
+// FencedLock a DistLock handing each acquisition a monotonically increasing fencing token
+type FencedLock interface {
+	DistLock
+	RunCriticalFenced(ID string, fn func(fence int64) (interface{}, error)) (interface{}, error)
+}
+
+// FencedCache a cache rejecting writes fenced by a token older than the last it accepted
+type FencedCache interface {
+	Cache
+	// SetFenced sets a circuit, returning ErrStaleFence if fence is stale
+	SetFenced(ID string, circuit *Circuit, fence int64) error
+}
+
 // Log delegate to log event
 type Log func(message string, context interface{})
+
+// WindowKind sliding window kind
+type WindowKind int
+
+const (
+	NoWindow WindowKind = iota
+	CountWindow
+	TimeWindow
+)
+
+// Policy a breaker's trip & reset rules, handed to a TransitionStore
+type Policy struct {
+	Threshold     int
+	GracePeriodMs int64
+
+	Window               WindowKind
+	WindowSize           int
+	WindowSizeMs         int64
+	WindowBuckets        int
+	FailureRateThreshold float64
+	MinimumCalls         int
+
+	SlowCallDurationMs    int64
+	SlowCallRateThreshold float64
+
+	HalfOpenMaxProbes        int
+	HalfOpenSuccessThreshold int
+	ProbeLeaseMs             int64
+}
+
+// Outcome of a call made through a circuit
+type Outcome struct {
+	Slow bool
+	// Probe whether the call held a half open probe slot
+	Probe bool
+}
+
+// Admission whether a call may run against a circuit
+type Admission int
+
+const (
+	Admitted Admission = iota
+	AdmittedProbe
+	RejectedOpen
+	RejectedProbeLimit
+)
+
+// Transition a circuit state change
+type Transition struct {
+	From State
+	To   State
+}
+
+// Notification a circuit transition, published by the node which made it
+type Notification struct {
+	ID   string
+	Node string
+	Transition
+	// Cause message of the failure which caused the transition, if any
+	Cause string
+	// Failures, Calls, FailedCalls & SlowCalls the circuit's counts after the transition
+	Failures    int
+	Calls       int
+	FailedCalls int
+	SlowCalls   int
+	At          time.Time
+}
+
+// Notifier publishes circuit transitions between nodes
+type Notifier interface {
+	Publish(notification Notification) error
+	// Subscribe calls fn with every notification published (including this
+	// node's), until the returned unsubscribe is called
+	Subscribe(fn func(notification Notification)) (unsubscribe func() error, err error)
+}
+
+// Invalidator a cache holding local copies of circuits, which can be dropped
+// when another node changes them
+type Invalidator interface {
+	Invalidate(ID string)
+}
@@ -0,0 +1,24 @@
+package schema
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateMovesUnversionedCircuitForward(t *testing.T) {
+	circuit := &Circuit{State: Open, Failures: 3}
+
+	require.NoError(t, Migrate(circuit))
+	require.Equal(t, CurrentSchemaVersion, circuit.SchemaVersion)
+	require.Equal(t, Open, circuit.State)
+	require.Equal(t, 3, circuit.Failures)
+}
+
+func TestMigrateRejectsNewerSchemaVersion(t *testing.T) {
+	circuit := &Circuit{SchemaVersion: CurrentSchemaVersion + 1}
+
+	err := Migrate(circuit)
+	require.True(t, errors.Is(err, ErrSchemaVersion))
+}
@@ -0,0 +1,419 @@
+package dcb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/danielglennross/go-dcb/policies"
+	"github.com/danielglennross/go-dcb/schema"
+)
+
+// store applies circuit transitions atomically
+type store interface {
+	get(ID string) (*schema.Circuit, error)
+	checkState(ID string) (*schema.Circuit, error)
+	// tryHalfOpen, recordSuccess & recordFailure return the circuit after a
+	// transition alongside it, nil without one
+	tryHalfOpen(ID string) (schema.Admission, *schema.Transition, *schema.Circuit, error)
+	recordSuccess(ID string, out schema.Outcome) (*schema.Transition, *schema.Circuit, error)
+	recordFailure(ID string, out schema.Outcome) (*schema.Transition, *schema.Circuit, error)
+	// update applies fn to a circuit, storing it if fn reports a change
+	update(ID string, fn func(circuit *schema.Circuit) bool) error
+}
+
+// transactor applies changes to a circuit atomically
+type transactor interface {
+	get(ID string) (*schema.Circuit, error)
+	// peek reads a circuit outside any lock, from a LayeredCache's local copy
+	peek(ID string) (*schema.Circuit, error)
+	// apply applies fn to a circuit (created closed if it doesn't exist),
+	// storing it if fn reports a change
+	apply(ID string, fn func(circuit *schema.Circuit) bool) (*schema.Circuit, error)
+}
+
+// lockTx applies changes to a cache inside a DistLock, fencing its writes
+// if the lock is a FencedLock & the cache a FencedCache
+type lockTx struct {
+	cache schema.Cache
+	lock  schema.DistLock
+}
+
+// runCritical runs fn inside the lock, handing it a set which writes to the cache
+func (tx *lockTx) runCritical(ID string, fn func(set func(circuit *schema.Circuit) error) (interface{}, error)) (interface{}, error) {
+	fl, fencedLock := tx.lock.(schema.FencedLock)
+	fc, fencedCache := tx.cache.(schema.FencedCache)
+
+	if !fencedLock || !fencedCache {
+		return tx.lock.RunCritical(ID, func() (interface{}, error) {
+			return fn(func(circuit *schema.Circuit) error {
+				return tx.cache.Set(ID, circuit)
+			})
+		})
+	}
+
+	return fl.RunCriticalFenced(ID, func(fence int64) (interface{}, error) {
+		return fn(func(circuit *schema.Circuit) error {
+			return fc.SetFenced(ID, circuit, fence)
+		})
+	})
+}
+
+func (tx *lockTx) get(ID string) (*schema.Circuit, error) {
+	res, err := tx.lock.RunCritical(ID, func() (interface{}, error) {
+		return tx.cache.Get(ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(*schema.Circuit), nil
+}
+
+func (tx *lockTx) peek(ID string) (*schema.Circuit, error) {
+	return tx.cache.Get(ID)
+}
+
+// latest reads a circuit inside the lock, past a LayeredCache's local copy,
+// so a change another node made since it was copied isn't written over
+func (tx *lockTx) latest(ID string) (*schema.Circuit, error) {
+	if lc, ok := tx.cache.(schema.LayeredCache); ok {
+		return lc.GetLatest(ID)
+	}
+	return tx.cache.Get(ID)
+}
+
+func (tx *lockTx) apply(ID string, fn func(circuit *schema.Circuit) bool) (*schema.Circuit, error) {
+	res, err := tx.runCritical(ID, func(set func(circuit *schema.Circuit) error) (interface{}, error) {
+		circuit, err := tx.latest(ID)
+		if err != nil {
+			return nil, err
+		}
+
+		created := circuit == nil
+		if created {
+			circuit = &schema.Circuit{State: schema.Closed}
+		}
+
+		if fn(circuit) || created {
+			if err := set(circuit); err != nil {
+				return nil, err
+			}
+		}
+		return circuit, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(*schema.Circuit), nil
+}
+
+// casTx applies changes to a versioned cache optimistically, retrying on conflict
+type casTx struct {
+	cache   schema.VersionedCache
+	retries int
+}
+
+func (tx *casTx) get(ID string) (*schema.Circuit, error) {
+	circuit, _, err := tx.cache.GetVersioned(ID)
+	return circuit, err
+}
+
+func (tx *casTx) peek(ID string) (*schema.Circuit, error) {
+	return tx.cache.Get(ID)
+}
+
+func (tx *casTx) apply(ID string, fn func(circuit *schema.Circuit) bool) (*schema.Circuit, error) {
+	for i := 0; i < tx.retries; i++ {
+		circuit, version, err := tx.cache.GetVersioned(ID)
+		if err != nil {
+			return nil, err
+		}
+
+		created := circuit == nil
+		if created {
+			circuit = &schema.Circuit{State: schema.Closed}
+		}
+
+		if !fn(circuit) && !created {
+			return circuit, nil
+		}
+
+		ok, err := tx.cache.CompareAndSet(ID, circuit, version)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return circuit, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Could not update ID %s, version changed %d times", ID, tx.retries)
+}
+
+// txStore runs transitions through a transactor
+type txStore struct {
+	breaker *CircuitBreaker
+	tx      transactor
+}
+
+func (s *txStore) get(ID string) (*schema.Circuit, error) {
+	return s.tx.get(ID)
+}
+
+// checkState only reads, so it's served without the lock (from a LayeredCache's
+// local copy), applying only to create a circuit that doesn't exist
+func (s *txStore) checkState(ID string) (*schema.Circuit, error) {
+	if circuit, err := s.tx.peek(ID); err != nil || circuit != nil {
+		return circuit, err
+	}
+	return s.tx.apply(ID, func(circuit *schema.Circuit) bool {
+		return false
+	})
+}
+
+// unchanged reports whether fn leaves the circuit as peeked unchanged, so
+// there's nothing to apply, e.g. a success on a closed circuit without a window
+func (s *txStore) unchanged(ID string, fn func(circuit *schema.Circuit) bool) bool {
+	circuit, err := s.tx.peek(ID)
+	return err == nil && circuit != nil && !fn(circuit)
+}
+
+func (s *txStore) tryHalfOpen(ID string) (schema.Admission, *schema.Transition, *schema.Circuit, error) {
+	var admission schema.Admission
+	var transition *schema.Transition
+
+	circuit, err := s.tx.apply(ID, func(circuit *schema.Circuit) bool {
+		var changed bool
+		admission, transition, changed = s.breaker.tryHalfOpen(circuit, time.Now())
+		return changed
+	})
+	return admission, transition, transitioned(transition, circuit), err
+}
+
+func (s *txStore) recordSuccess(ID string, out schema.Outcome) (*schema.Transition, *schema.Circuit, error) {
+	var transition *schema.Transition
+
+	record := func(circuit *schema.Circuit) bool {
+		var changed bool
+		transition, changed = s.breaker.recordSuccess(circuit, out, time.Now())
+		return changed
+	}
+	if s.unchanged(ID, record) {
+		return nil, nil, nil
+	}
+
+	circuit, err := s.tx.apply(ID, record)
+	return transition, transitioned(transition, circuit), err
+}
+
+func (s *txStore) recordFailure(ID string, out schema.Outcome) (*schema.Transition, *schema.Circuit, error) {
+	var transition *schema.Transition
+
+	circuit, err := s.tx.apply(ID, func(circuit *schema.Circuit) bool {
+		var changed bool
+		transition, changed = s.breaker.recordFailure(circuit, out, time.Now())
+		return changed
+	})
+	return transition, transitioned(transition, circuit), err
+}
+
+// transitioned the circuit after a transition, nil without one
+func transitioned(transition *schema.Transition, circuit *schema.Circuit) *schema.Circuit {
+	if transition == nil {
+		return nil
+	}
+	return circuit
+}
+
+func (s *txStore) update(ID string, fn func(circuit *schema.Circuit) bool) error {
+	_, err := s.tx.apply(ID, fn)
+	return err
+}
+
+// nativeStore hands transitions to a cache which runs them itself
+type nativeStore struct {
+	breaker *CircuitBreaker
+	ts      schema.TransitionStore
+}
+
+func (s *nativeStore) get(ID string) (*schema.Circuit, error) {
+	return s.ts.Get(ID)
+}
+
+func (s *nativeStore) checkState(ID string) (*schema.Circuit, error) {
+	return s.ts.CheckState(ID)
+}
+
+func (s *nativeStore) tryHalfOpen(ID string) (schema.Admission, *schema.Transition, *schema.Circuit, error) {
+	return s.ts.TryHalfOpen(ID, s.breaker.policy(), time.Now())
+}
+
+func (s *nativeStore) recordSuccess(ID string, out schema.Outcome) (*schema.Transition, *schema.Circuit, error) {
+	return s.ts.RecordSuccess(ID, s.breaker.policy(), out, time.Now())
+}
+
+func (s *nativeStore) recordFailure(ID string, out schema.Outcome) (*schema.Transition, *schema.Circuit, error) {
+	return s.ts.RecordFailure(ID, s.breaker.policy(), out, time.Now())
+}
+
+func (s *nativeStore) update(ID string, fn func(circuit *schema.Circuit) bool) error {
+	return s.ts.Update(ID, func(circuit *schema.Circuit) {
+		fn(circuit)
+	})
+}
+
+// policy the breaker's options, as handed to a TransitionStore
+func (breaker *CircuitBreaker) policy() schema.Policy {
+	return schema.Policy{
+		Threshold:                breaker.threshold,
+		GracePeriodMs:            breaker.gracePeriodMs,
+		Window:                   breaker.window,
+		WindowSize:               breaker.windowSize,
+		WindowSizeMs:             breaker.windowSizeMs,
+		WindowBuckets:            breaker.windowBucketCount,
+		FailureRateThreshold:     breaker.failureRateThreshold,
+		MinimumCalls:             breaker.minimumCalls,
+		SlowCallDurationMs:       breaker.slowCallDurationMs,
+		SlowCallRateThreshold:    breaker.slowCallRateThreshold,
+		HalfOpenMaxProbes:        breaker.halfOpenMaxProbes,
+		HalfOpenSuccessThreshold: breaker.halfOpenSuccessThreshold,
+		ProbeLeaseMs:             int64(breaker.probeLease() / time.Millisecond),
+	}
+}
+
+// tryHalfOpen moves an open circuit, past its grace period, to half open and
+// takes a probe slot for the call if half open probes are limited
+func (breaker *CircuitBreaker) tryHalfOpen(circuit *schema.Circuit, now time.Time) (schema.Admission, *schema.Transition, bool) {
+	var transition *schema.Transition
+
+	switch circuit.State {
+	case schema.Closed, schema.ForceClosed, schema.Disabled:
+		return schema.Admitted, nil, false
+	case schema.Isolate:
+		return schema.RejectedOpen, nil, false
+	case schema.Open:
+		if now.Sub(circuit.OpenedAt) <= time.Millisecond*time.Duration(breaker.gracePeriodMs) {
+			return schema.RejectedOpen, nil, false
+		}
+
+		circuit.State = schema.HalfOpen
+		resetProbes(circuit)
+
+		transition = &schema.Transition{From: schema.Open, To: schema.HalfOpen}
+	}
+
+	if breaker.halfOpenMaxProbes < 1 {
+		return schema.Admitted, transition, transition != nil
+	}
+
+	if circuit.Probes >= breaker.halfOpenMaxProbes {
+		if now.Before(circuit.ProbesExpireAt) {
+			return schema.RejectedProbeLimit, transition, transition != nil
+		}
+		// probes outlived their lease, assume their callers died
+		circuit.Probes = 0
+	}
+
+	circuit.Probes++
+	circuit.ProbesExpireAt = now.Add(breaker.probeLease())
+
+	return schema.AdmittedProbe, transition, true
+}
+
+func (breaker *CircuitBreaker) recordFailure(circuit *schema.Circuit, out schema.Outcome, now time.Time) (*schema.Transition, bool) {
+	switch circuit.State {
+	case schema.Open, schema.Isolate, schema.ForceClosed:
+		return nil, false
+	case schema.Disabled:
+		circuit.Failures++
+		breaker.recordOutcome(&circuit.Window, true, out.Slow, now)
+		return nil, true
+	}
+
+	circuit.Failures++
+	breaker.recordOutcome(&circuit.Window, true, out.Slow, now)
+
+	if circuit.State == schema.HalfOpen || breaker.shouldTrip(circuit, now) {
+		from := circuit.State
+		openCircuit(circuit, now)
+		return &schema.Transition{From: from, To: schema.Open}, true
+	}
+	return nil, true
+}
+
+func (breaker *CircuitBreaker) recordSuccess(circuit *schema.Circuit, out schema.Outcome, now time.Time) (*schema.Transition, bool) {
+	switch circuit.State {
+	case schema.Disabled:
+		if breaker.window == schema.NoWindow {
+			return nil, false
+		}
+
+		breaker.recordOutcome(&circuit.Window, false, out.Slow, now)
+		return nil, true
+	case schema.Closed:
+		if breaker.window == schema.NoWindow {
+			return nil, false
+		}
+
+		breaker.recordOutcome(&circuit.Window, false, out.Slow, now)
+
+		if breaker.shouldTrip(circuit, now) {
+			openCircuit(circuit, now)
+			return &schema.Transition{From: schema.Closed, To: schema.Open}, true
+		}
+		return nil, true
+	case schema.HalfOpen:
+		if out.Probe {
+			returnProbe(circuit)
+		}
+		circuit.ProbeSuccesses++
+
+		if circuit.ProbeSuccesses < breaker.halfOpenSuccessThreshold {
+			return nil, true
+		}
+
+		closeCircuit(circuit)
+		return &schema.Transition{From: schema.HalfOpen, To: schema.Closed}, true
+	}
+	return nil, false
+}
+
+// probeLease how long a probe slot is held before it's presumed abandoned, the
+// longest a call can run (every attempt timing out, with the longest backoff
+// between each) plus the grace period. A backoff which isn't policies.Bounded
+// must fit its waits in the grace period
+func (breaker *CircuitBreaker) probeLease() time.Duration {
+	lease := time.Millisecond * time.Duration(breaker.timeoutMs*int64(breaker.retry)+breaker.gracePeriodMs)
+	if bounded, ok := breaker.backoff.(policies.Bounded); ok {
+		lease += bounded.MaxDuration() * time.Duration(breaker.retry-1)
+	}
+	return lease
+}
+
+// openCircuit opens a circuit, its counts are kept (for events & stats) until it closes
+func openCircuit(circuit *schema.Circuit, now time.Time) {
+	circuit.State = schema.Open
+	circuit.OpenedAt = now
+	resetProbes(circuit)
+}
+
+func closeCircuit(circuit *schema.Circuit) {
+	circuit.State = schema.Closed
+	circuit.OpenedAt = time.Time{}
+	circuit.Failures = 0
+	circuit.Window = schema.Window{}
+	resetProbes(circuit)
+}
+
+func returnProbe(circuit *schema.Circuit) bool {
+	if circuit.State != schema.HalfOpen || circuit.Probes < 1 {
+		return false
+	}
+	circuit.Probes--
+	return true
+}
+
+func resetProbes(circuit *schema.Circuit) {
+	circuit.Probes = 0
+	circuit.ProbeSuccesses = 0
+	circuit.ProbesExpireAt = time.Time{}
+}
@@ -0,0 +1,254 @@
+package dcb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/danielglennross/go-dcb/cache"
+	"github.com/danielglennross/go-dcb/schema"
+	"github.com/stretchr/testify/require"
+)
+
+type conflictingCache struct {
+	*cache.MemoryCache
+}
+
+func (c conflictingCache) CompareAndSet(ID string, circuit *schema.Circuit, expectedVersion int64) (bool, error) {
+	return false, nil
+}
+
+type unavailableLock struct{}
+
+func (unavailableLock) RunCritical(ID string, fn func() (interface{}, error)) (interface{}, error) {
+	return nil, fmt.Errorf("%w for %s", schema.ErrLockNotAcquired, ID)
+}
+
+// fencedCache hands out fences from its lock & checks them on write
+type fencedCache struct {
+	*cache.MemoryCache
+	next     int64
+	accepted int64
+}
+
+func (c *fencedCache) RunCriticalFenced(ID string, fn func(fence int64) (interface{}, error)) (interface{}, error) {
+	return c.RunCritical(ID, func() (interface{}, error) {
+		c.next++
+		return fn(c.next)
+	})
+}
+
+func (c *fencedCache) SetFenced(ID string, circuit *schema.Circuit, fence int64) error {
+	if fence < c.accepted {
+		return schema.ErrStaleFence
+	}
+	c.accepted = fence
+	return c.Set(ID, circuit)
+}
+
+func TestLockTxFencesWrites(t *testing.T) {
+	c := &fencedCache{MemoryCache: cache.NewMemoryCache()}
+
+	breaker, err := NewCircuitBreaker(c, c, Retry(1))
+	require.NoError(t, err)
+	defer breaker.Destroy()
+
+	_, err = breaker.Fire("id", func() (interface{}, error) {
+		return nil, fmt.Errorf("boom")
+	})
+	require.Error(t, err)
+	require.Equal(t, c.next, c.accepted)
+
+	// a writer whose lease expired while another's write was accepted
+	c.accepted = c.next + 10
+
+	_, err = breaker.Fire("id", func() (interface{}, error) {
+		return nil, fmt.Errorf("boom")
+	})
+	require.True(t, errors.Is(err, schema.ErrStaleFence))
+}
+
+func TestLockTxReadsPastNearCacheCopy(t *testing.T) {
+	remote := cache.NewMemoryCache()
+	nodeA := cache.NewNearCache(remote, cache.StalenessMs(60000))
+	nodeB := cache.NewNearCache(remote, cache.StalenessMs(60000))
+
+	breakerA, err := NewCircuitBreaker(nodeA, remote, Retry(1))
+	require.NoError(t, err)
+	defer breakerA.Destroy()
+
+	breakerB, err := NewCircuitBreaker(nodeB, remote, Retry(1))
+	require.NoError(t, err)
+	defer breakerB.Destroy()
+
+	// node B copies the circuit while it's closed
+	_, err = breakerB.Fire("id", func() (interface{}, error) { return true, nil })
+	require.NoError(t, err)
+
+	require.True(t, breakerA.Isolate("id"))
+
+	_, _ = breakerB.Fire("id", func() (interface{}, error) {
+		return nil, fmt.Errorf("boom")
+	})
+
+	circuit, err := remote.Get("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Isolate, circuit.State)
+}
+
+// countingRemote counts the circuits read from it
+type countingRemote struct {
+	*cache.MemoryCache
+	reads int32
+}
+
+func (r *countingRemote) Get(ID string) (*schema.Circuit, error) {
+	atomic.AddInt32(&r.reads, 1)
+	return r.MemoryCache.Get(ID)
+}
+
+func (r *countingRemote) GetVersioned(ID string) (*schema.Circuit, int64, error) {
+	atomic.AddInt32(&r.reads, 1)
+	return r.MemoryCache.GetVersioned(ID)
+}
+
+func TestLockTxServesFiresFromNearCacheCopy(t *testing.T) {
+	remote := &countingRemote{MemoryCache: cache.NewMemoryCache()}
+	lock := &countingLock{MemoryCache: remote.MemoryCache}
+
+	breaker, err := NewCircuitBreaker(cache.NewNearCache(remote, cache.StalenessMs(60000)), lock, Retry(1))
+	require.NoError(t, err)
+	defer breaker.Destroy()
+
+	// the first copies the circuit
+	_, err = breaker.Fire("id", func() (interface{}, error) { return true, nil })
+	require.NoError(t, err)
+
+	reads, runs := atomic.LoadInt32(&remote.reads), atomic.LoadInt32(&lock.runs)
+	for i := 0; i < 3; i++ {
+		_, err = breaker.Fire("id", func() (interface{}, error) { return true, nil })
+		require.NoError(t, err)
+	}
+	require.Equal(t, reads, atomic.LoadInt32(&remote.reads))
+	require.Equal(t, runs, atomic.LoadInt32(&lock.runs))
+}
+
+func TestLockFailureFailOpenRunsCall(t *testing.T) {
+	breaker, err := NewCircuitBreaker(cache.NewMemoryCache(), unavailableLock{}, LockFailure(FailOpen))
+	require.NoError(t, err)
+	defer breaker.Destroy()
+
+	res, err := breaker.Fire("id", func() (interface{}, error) {
+		return 5, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 5, res)
+}
+
+func TestLockFailureFailClosedRejectsCall(t *testing.T) {
+	breaker, err := NewCircuitBreaker(cache.NewMemoryCache(), unavailableLock{}, LockFailure(FailClosed))
+	require.NoError(t, err)
+	defer breaker.Destroy()
+
+	fallbacks := make(chan string, 1)
+	breaker.OnFallback(func(e Event) { fallbacks <- e.ID })
+
+	_, err = breaker.Fire("id", func() (interface{}, error) {
+		t.Fatal("fn should not run")
+		return nil, nil
+	})
+	require.True(t, errors.Is(err, schema.ErrLockNotAcquired))
+	require.Equal(t, "id", <-fallbacks)
+}
+
+func TestCASStoreWithoutLock(t *testing.T) {
+	c := cache.NewMemoryCache()
+
+	breaker, err := NewCircuitBreaker(c, nil, Threshold(1000), Retry(1))
+	require.NoError(t, err)
+	defer breaker.Destroy()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = breaker.Fire("id", func() (interface{}, error) {
+				return nil, fmt.Errorf("boom")
+			})
+		}()
+	}
+	wg.Wait()
+
+	stats, err := breaker.Stats("id")
+	require.NoError(t, err)
+	require.Equal(t, 50, stats.Failures)
+}
+
+func TestCASStoreGivesUpAfterRetries(t *testing.T) {
+	c := conflictingCache{cache.NewMemoryCache()}
+
+	breaker, err := NewCircuitBreaker(c, nil, CASRetries(3))
+	require.NoError(t, err)
+	defer breaker.Destroy()
+
+	_, err = breaker.Fire("id", func() (interface{}, error) {
+		return true, nil
+	})
+	require.True(t, errors.Is(err, ErrStore))
+	require.EqualError(t, err, "circuit store failed: Could not update ID id, version changed 3 times")
+}
+
+func TestNewCircuitBreakerRequiresLockOrCapableCache(t *testing.T) {
+	type plainCache struct{ schema.Cache }
+
+	_, err := NewCircuitBreaker(plainCache{cache.NewMemoryCache()}, nil)
+	require.Error(t, err)
+}
+
+func TestForceClosedNeverRejectsOrCounts(t *testing.T) {
+	breaker := newTestBreaker(t)
+	require.True(t, breaker.ForceClose("id"))
+
+	for i := 0; i < 5; i++ {
+		_, err := breaker.Fire("id", func() (interface{}, error) {
+			return nil, fmt.Errorf("boom")
+		})
+		require.EqualError(t, err, "retries exhausted for ID: id after 1 attempt: boom")
+	}
+
+	stats, err := breaker.Stats("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.ForceClosed, stats.State)
+	require.Equal(t, 0, stats.Failures)
+}
+
+func TestDisabledCountsButNeverTrips(t *testing.T) {
+	breaker := newTestBreaker(t, SlidingWindowCount(10), MinimumCalls(1))
+	require.True(t, breaker.Disable("id"))
+
+	fallbacks := make(chan string, 5)
+	breaker.OnFallback(func(e Event) { fallbacks <- e.ID })
+
+	for i := 0; i < 5; i++ {
+		_, err := breaker.Fire("id", func() (interface{}, error) {
+			return nil, fmt.Errorf("boom")
+		})
+		require.EqualError(t, err, "retries exhausted for ID: id after 1 attempt: boom")
+		require.Equal(t, "id", <-fallbacks)
+	}
+
+	stats, err := breaker.Stats("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Disabled, stats.State)
+	require.Equal(t, 5, stats.Failures)
+	require.Equal(t, 5, stats.FailedCalls)
+
+	require.True(t, breaker.Reset("id"))
+
+	stats, err = breaker.Stats("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Closed, stats.State)
+}
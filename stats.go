@@ -0,0 +1,46 @@
+package dcb
+
+import (
+	"time"
+
+	"github.com/danielglennross/go-dcb/schema"
+)
+
+// Stats circuit statistics, window figures are only kept when a sliding
+// window is configured
+type Stats struct {
+	ID           string
+	State        schema.State
+	Failures     int
+	Calls        int
+	FailedCalls  int
+	SlowCalls    int
+	FailureRate  float64
+	SlowCallRate float64
+}
+
+// Stats gets a circuit's statistics
+func (breaker *CircuitBreaker) Stats(ID string) (Stats, error) {
+	circuit, err := breaker.store.get(ID)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	stats := Stats{ID: ID, State: schema.Closed}
+
+	if circuit == nil {
+		return stats, nil
+	}
+
+	totals := breaker.totals(&circuit.Window, time.Now())
+
+	stats.State = circuit.State
+	stats.Failures = circuit.Failures
+	stats.Calls = totals.calls
+	stats.FailedCalls = totals.failures
+	stats.SlowCalls = totals.slowCalls
+	stats.FailureRate = totals.failureRate()
+	stats.SlowCallRate = totals.slowCallRate()
+
+	return stats, nil
+}
@@ -0,0 +1,181 @@
+package dcb
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/danielglennross/go-dcb/cache"
+	"github.com/danielglennross/go-dcb/schema"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/require"
+)
+
+var errBoom = errors.New("boom")
+
+// storeStep a call (or manual action) & the circuit it should leave behind
+type storeStep struct {
+	wait   time.Duration
+	manage func(breaker *CircuitBreaker) bool
+	fail   bool
+	// err the call's error, nil if it should succeed
+	err      error
+	state    schema.State
+	failures int
+}
+
+type storeCase struct {
+	name    string
+	options []CircuitBreakerOption
+	steps   []storeStep
+}
+
+// storeCases transitions every store must make alike
+var storeCases = []storeCase{
+	{
+		name:    "threshold trips",
+		options: []CircuitBreakerOption{Threshold(1), GracePeriodMs(60000)},
+		steps: []storeStep{
+			{fail: true, err: errBoom, state: schema.Closed, failures: 1},
+			{fail: true, err: errBoom, state: schema.Open, failures: 2},
+			{err: ErrCircuitOpen, state: schema.Open, failures: 2},
+		},
+	},
+	{
+		name:    "half open success closes",
+		options: []CircuitBreakerOption{Threshold(0), GracePeriodMs(20)},
+		steps: []storeStep{
+			{fail: true, err: errBoom, state: schema.Open, failures: 1},
+			{wait: 40 * time.Millisecond, state: schema.Closed},
+		},
+	},
+	{
+		name:    "half open failure reopens",
+		options: []CircuitBreakerOption{Threshold(0), GracePeriodMs(20)},
+		steps: []storeStep{
+			{fail: true, err: errBoom, state: schema.Open, failures: 1},
+			{wait: 40 * time.Millisecond, fail: true, err: errBoom, state: schema.Open, failures: 2},
+			{err: ErrCircuitOpen, state: schema.Open, failures: 2},
+		},
+	},
+	{
+		name:    "half open success threshold",
+		options: []CircuitBreakerOption{Threshold(0), GracePeriodMs(20), HalfOpenMaxProbes(1), HalfOpenSuccessThreshold(2)},
+		steps: []storeStep{
+			{fail: true, err: errBoom, state: schema.Open, failures: 1},
+			{wait: 40 * time.Millisecond, state: schema.HalfOpen, failures: 1},
+			{state: schema.Closed},
+		},
+	},
+	{
+		name:    "count window trips on failure rate",
+		options: []CircuitBreakerOption{SlidingWindowCount(4), MinimumCalls(4), FailureRateThreshold(50), GracePeriodMs(60000)},
+		steps: []storeStep{
+			{state: schema.Closed},
+			{state: schema.Closed},
+			{fail: true, err: errBoom, state: schema.Closed, failures: 1},
+			{fail: true, err: errBoom, state: schema.Open, failures: 2},
+			{err: ErrCircuitOpen, state: schema.Open, failures: 2},
+		},
+	},
+	{
+		name:    "time window trips on failure rate",
+		options: []CircuitBreakerOption{SlidingWindowTimeMs(60000, 10), MinimumCalls(2), FailureRateThreshold(50), GracePeriodMs(60000)},
+		steps: []storeStep{
+			{fail: true, err: errBoom, state: schema.Closed, failures: 1},
+			{state: schema.Open, failures: 1},
+		},
+	},
+	{
+		name:    "isolate rejects until reset",
+		options: []CircuitBreakerOption{},
+		steps: []storeStep{
+			{manage: func(breaker *CircuitBreaker) bool { return breaker.Isolate("id") }, err: ErrCircuitIsolated, state: schema.Isolate},
+			{manage: func(breaker *CircuitBreaker) bool { return breaker.Reset("id") }, state: schema.Closed},
+		},
+	},
+	{
+		name:    "force closed never trips or counts",
+		options: []CircuitBreakerOption{Threshold(0)},
+		steps: []storeStep{
+			{manage: func(breaker *CircuitBreaker) bool { return breaker.ForceClose("id") }, fail: true, err: errBoom, state: schema.ForceClosed},
+			{fail: true, err: errBoom, state: schema.ForceClosed},
+		},
+	},
+	{
+		name:    "disabled counts but never trips",
+		options: []CircuitBreakerOption{Threshold(0)},
+		steps: []storeStep{
+			{manage: func(breaker *CircuitBreaker) bool { return breaker.Disable("id") }, fail: true, err: errBoom, state: schema.Disabled, failures: 1},
+			{fail: true, err: errBoom, state: schema.Disabled, failures: 2},
+		},
+	},
+}
+
+// newTestRedisStore a RedisStore on the Redis at DCB_REDIS_ADDR, flushing
+// database 15 (the cache package's tests use 14). Without DCB_REDIS_ADDR the
+// test is skipped
+func newTestRedisStore(t *testing.T) *cache.RedisStore {
+	addr := os.Getenv("DCB_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("DCB_REDIS_ADDR not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr, DB: 15})
+	require.NoError(t, client.FlushDB().Err())
+
+	t.Cleanup(func() { client.Close() })
+	return cache.NewRedisStoreFromClient(client, cache.TTL(0))
+}
+
+func TestStoresMakeSameTransitions(t *testing.T) {
+	stores := map[string]func(t *testing.T) (schema.Cache, schema.DistLock){
+		"lock": func(t *testing.T) (schema.Cache, schema.DistLock) {
+			c := cache.NewMemoryCache()
+			return c, c
+		},
+		"cas": func(t *testing.T) (schema.Cache, schema.DistLock) {
+			return cache.NewMemoryCache(), nil
+		},
+		"redis": func(t *testing.T) (schema.Cache, schema.DistLock) {
+			return newTestRedisStore(t), nil
+		},
+	}
+
+	for storeName, newStore := range stores {
+		for _, tc := range storeCases {
+			t.Run(storeName+"/"+tc.name, func(t *testing.T) {
+				c, lock := newStore(t)
+
+				breaker, err := NewCircuitBreaker(c, lock, append([]CircuitBreakerOption{TimeoutMs(1000), Retry(1)}, tc.options...)...)
+				require.NoError(t, err)
+				defer breaker.Destroy()
+
+				for i, step := range tc.steps {
+					time.Sleep(step.wait)
+					if step.manage != nil {
+						require.True(t, step.manage(breaker), "step %d", i)
+					}
+
+					_, err := breaker.Fire("id", func() (interface{}, error) {
+						if step.fail {
+							return nil, errBoom
+						}
+						return true, nil
+					})
+					if step.err == nil {
+						require.NoError(t, err, "step %d", i)
+					} else {
+						require.True(t, errors.Is(err, step.err), "step %d: %v", i, err)
+					}
+
+					stats, err := breaker.Stats("id")
+					require.NoError(t, err)
+					require.Equal(t, step.state, stats.State, "step %d", i)
+					require.Equal(t, step.failures, stats.Failures, "step %d", i)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package dcb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/danielglennross/go-dcb/schema"
+)
+
+// TypedFn typed circuit breaker func
+type TypedFn[T any] func(ctx context.Context) (T, error)
+
+// TypedFallbackFn typed fallback, see FallbackFn
+type TypedFallbackFn[T any] func(ctx context.Context, err error) (T, error)
+
+// Breaker typed circuit breaker, it shares the cache, lock, options & events
+// of the CircuitBreaker it wraps
+type Breaker[T any] struct {
+	*CircuitBreaker
+}
+
+// NewBreaker typed ctor
+func NewBreaker[T any](cache schema.Cache, lock schema.DistLock, options ...CircuitBreakerOption) (*Breaker[T], error) {
+	cb, err := NewCircuitBreaker(cache, lock, options...)
+	if err != nil {
+		return nil, err
+	}
+	return Typed[T](cb), nil
+}
+
+// Typed wraps an existing circuit breaker with a typed API
+func Typed[T any](breaker *CircuitBreaker) *Breaker[T] {
+	return &Breaker[T]{breaker}
+}
+
+// Fire the typed breaker
+func (breaker *Breaker[T]) Fire(ctx context.Context, ID string, fn TypedFn[T]) (T, error) {
+	return Fire(ctx, breaker.CircuitBreaker, ID, fn)
+}
+
+// FireWithFallback fire the typed breaker, running fallback if the call is rejected or fails
+func (breaker *Breaker[T]) FireWithFallback(ctx context.Context, ID string, fn TypedFn[T], fallback TypedFallbackFn[T]) (T, error) {
+	return FireWithFallback(ctx, breaker.CircuitBreaker, ID, fn, fallback)
+}
+
+// Fire a circuit breaker with a typed func
+func Fire[T any](ctx context.Context, breaker *CircuitBreaker, ID string, fn TypedFn[T]) (T, error) {
+	res, err := breaker.FireContext(ctx, ID, func(ctx context.Context) (interface{}, error) {
+		return fn(ctx)
+	})
+	return typedResult[T](ID, res, err)
+}
+
+// FireWithFallback fire a circuit breaker with a typed func, running fallback
+// (in place of the breaker's) if the call is rejected or fails. A nil
+// fallback runs none, as with FireContextWithFallback
+func FireWithFallback[T any](ctx context.Context, breaker *CircuitBreaker, ID string, fn TypedFn[T], fallback TypedFallbackFn[T]) (T, error) {
+	var untyped FallbackFn
+	if fallback != nil {
+		untyped = func(ctx context.Context, err error) (interface{}, error) {
+			return fallback(ctx, err)
+		}
+	}
+
+	res, err := breaker.FireContextWithFallback(ctx, ID, func(ctx context.Context) (interface{}, error) {
+		return fn(ctx)
+	}, untyped)
+	return typedResult[T](ID, res, err)
+}
+
+// typedResult asserts an untyped result is a T
+func typedResult[T any](ID string, res interface{}, err error) (T, error) {
+	var zero T
+
+	if err != nil {
+		return zero, err
+	}
+	if res == nil {
+		return zero, nil
+	}
+
+	value, ok := res.(T)
+	if !ok {
+		return zero, fmt.Errorf("Unexpected result type %T for ID: %s", res, ID)
+	}
+	return value, nil
+}
@@ -0,0 +1,72 @@
+package dcb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTypedFireReturnsTypedResult(t *testing.T) {
+	breaker := Typed[int](newTestBreaker(t))
+
+	res, err := breaker.Fire(context.Background(), "id", func(ctx context.Context) (int, error) {
+		return 5, nil
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, 5, res)
+}
+
+func TestTypedFireReturnsZeroValueOnError(t *testing.T) {
+	breaker := Typed[string](newTestBreaker(t))
+
+	res, err := breaker.Fire(context.Background(), "id", func(ctx context.Context) (string, error) {
+		return "ignored", fmt.Errorf("boom")
+	})
+
+	require.EqualError(t, err, "retries exhausted for ID: id after 1 attempt: boom")
+	require.Equal(t, "", res)
+}
+
+func TestTypedFireReturnsNilInterfaceResult(t *testing.T) {
+	breaker := newTestBreaker(t)
+
+	res, err := Fire(context.Background(), breaker, "id", func(ctx context.Context) (error, error) {
+		return nil, nil
+	})
+
+	require.NoError(t, err)
+	require.Nil(t, res)
+}
+
+func TestTypedBreakerSharesUntypedBreaker(t *testing.T) {
+	untyped := newTestBreaker(t)
+	typed := Typed[int](untyped)
+
+	_, err := untyped.Fire("id", func() (interface{}, error) {
+		return 5, nil
+	})
+	require.NoError(t, err)
+	require.True(t, untyped.Isolate("id"))
+
+	_, err = typed.Fire(context.Background(), "id", func(ctx context.Context) (int, error) {
+		return 5, nil
+	})
+	require.True(t, errors.Is(err, ErrCircuitIsolated))
+}
+
+func TestTypedFireWithNilFallbackRunsNone(t *testing.T) {
+	breaker := Typed[int](newTestBreaker(t, Fallback(func(ctx context.Context, err error) (interface{}, error) {
+		return 1, nil
+	})))
+
+	res, err := breaker.FireWithFallback(context.Background(), "id", func(ctx context.Context) (int, error) {
+		return 0, fmt.Errorf("boom")
+	}, nil)
+
+	require.EqualError(t, err, "retries exhausted for ID: id after 1 attempt: boom")
+	require.Equal(t, 0, res)
+}
@@ -0,0 +1,113 @@
+package dcb
+
+import (
+	"time"
+
+	"github.com/danielglennross/go-dcb/schema"
+)
+
+// windowTotals outcomes recorded in a window
+type windowTotals struct {
+	calls     int
+	failures  int
+	slowCalls int
+}
+
+func (t windowTotals) failureRate() float64 {
+	if t.calls == 0 {
+		return 0
+	}
+	return float64(t.failures) * 100 / float64(t.calls)
+}
+
+func (t windowTotals) slowCallRate() float64 {
+	if t.calls == 0 {
+		return 0
+	}
+	return float64(t.slowCalls) * 100 / float64(t.calls)
+}
+
+func (breaker *CircuitBreaker) windowBuckets() int {
+	if breaker.window == schema.CountWindow {
+		return breaker.windowSize
+	}
+	return breaker.windowBucketCount
+}
+
+// windowHead the epoch of the most recent bucket
+func (breaker *CircuitBreaker) windowHead(window *schema.Window, now time.Time) int64 {
+	if breaker.window == schema.CountWindow {
+		return window.Seq - 1
+	}
+	spanMs := breaker.windowSizeMs / int64(breaker.windowBucketCount)
+	if spanMs < 1 {
+		spanMs = 1
+	}
+	return now.UnixNano() / int64(time.Millisecond) / spanMs
+}
+
+// recordOutcome records a call against the circuit's window
+func (breaker *CircuitBreaker) recordOutcome(window *schema.Window, failed, slow bool, now time.Time) {
+	if breaker.window == schema.NoWindow {
+		return
+	}
+
+	size := breaker.windowBuckets()
+	if len(window.Buckets) != size {
+		// window resized, start again
+		*window = schema.Window{Buckets: make([]schema.Bucket, size)}
+	}
+
+	if breaker.window == schema.CountWindow {
+		window.Seq++
+	}
+
+	epoch := breaker.windowHead(window, now)
+	bucket := &window.Buckets[epoch%int64(size)]
+	if bucket.Epoch != epoch || bucket.Calls == 0 {
+		*bucket = schema.Bucket{Epoch: epoch}
+	}
+
+	bucket.Calls++
+	if failed {
+		bucket.Failures++
+	}
+	if slow {
+		bucket.SlowCalls++
+	}
+}
+
+// totals sums the buckets still inside the window
+func (breaker *CircuitBreaker) totals(window *schema.Window, now time.Time) windowTotals {
+	var totals windowTotals
+	if breaker.window == schema.NoWindow {
+		return totals
+	}
+
+	size := int64(breaker.windowBuckets())
+	head := breaker.windowHead(window, now)
+
+	for _, bucket := range window.Buckets {
+		if bucket.Calls == 0 || bucket.Epoch > head || bucket.Epoch <= head-size {
+			continue
+		}
+		totals.calls += bucket.Calls
+		totals.failures += bucket.Failures
+		totals.slowCalls += bucket.SlowCalls
+	}
+	return totals
+}
+
+// shouldTrip whether a closed circuit should open
+func (breaker *CircuitBreaker) shouldTrip(circuit *schema.Circuit, now time.Time) bool {
+	if breaker.window == schema.NoWindow {
+		return circuit.Failures > breaker.threshold
+	}
+
+	totals := breaker.totals(&circuit.Window, now)
+	if totals.calls == 0 || totals.calls < breaker.minimumCalls {
+		return false
+	}
+	return totals.failureRate() >= breaker.failureRateThreshold ||
+		(breaker.slowCallDurationMs > 0 && totals.slowCallRate() >= breaker.slowCallRateThreshold)
+}
@@ -0,0 +1,125 @@
+package dcb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/danielglennross/go-dcb/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountWindowKeepsLastCalls(t *testing.T) {
+	breaker := newTestBreaker(t, SlidingWindowCount(4))
+	window := &schema.Window{}
+	now := time.Now()
+
+	for _, failed := range []bool{true, true, true, false, false, false, false} {
+		breaker.recordOutcome(window, failed, false, now)
+	}
+
+	totals := breaker.totals(window, now)
+	require.Equal(t, 4, totals.calls)
+	require.Equal(t, 0, totals.failures)
+}
+
+func TestTimeWindowExpiresOldBuckets(t *testing.T) {
+	breaker := newTestBreaker(t, SlidingWindowTimeMs(1000, 10))
+	window := &schema.Window{}
+	now := time.Now()
+
+	breaker.recordOutcome(window, true, false, now)
+	breaker.recordOutcome(window, true, false, now.Add(500*time.Millisecond))
+	breaker.recordOutcome(window, false, false, now.Add(900*time.Millisecond))
+
+	totals := breaker.totals(window, now.Add(900*time.Millisecond))
+	require.Equal(t, 3, totals.calls)
+	require.Equal(t, 2, totals.failures)
+
+	totals = breaker.totals(window, now.Add(1200*time.Millisecond))
+	require.Equal(t, 2, totals.calls)
+	require.Equal(t, 1, totals.failures)
+
+	totals = breaker.totals(window, now.Add(5*time.Second))
+	require.Equal(t, 0, totals.calls)
+}
+
+func TestWindowDoesNotTripBelowMinimumCalls(t *testing.T) {
+	breaker := newTestBreaker(t,
+		SlidingWindowCount(10),
+		MinimumCalls(5),
+		FailureRateThreshold(50),
+	)
+	circuit := &schema.Circuit{}
+	now := time.Now()
+
+	for i := 0; i < 4; i++ {
+		breaker.recordOutcome(&circuit.Window, true, false, now)
+	}
+	require.False(t, breaker.shouldTrip(circuit, now))
+
+	breaker.recordOutcome(&circuit.Window, false, false, now)
+	require.True(t, breaker.shouldTrip(circuit, now))
+}
+
+func TestWindowOpensCircuitOnFailureRate(t *testing.T) {
+	breaker := newTestBreaker(t,
+		SlidingWindowCount(4),
+		MinimumCalls(4),
+		FailureRateThreshold(50),
+	)
+
+	succeed := func() (interface{}, error) { return true, nil }
+	fail := func() (interface{}, error) { return nil, fmt.Errorf("boom") }
+
+	for _, fn := range []CircuitBreakerFn{succeed, succeed, fail} {
+		_, _ = breaker.Fire("id", fn)
+	}
+
+	circuit, err := breaker.cache.Get("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Closed, circuit.State)
+
+	_, _ = breaker.Fire("id", fail)
+
+	circuit, err = breaker.cache.Get("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Open, circuit.State)
+}
+
+func TestSlowCallsOpenCircuit(t *testing.T) {
+	breaker := newTestBreaker(t,
+		SlidingWindowCount(2),
+		MinimumCalls(2),
+		SlowCallDurationMs(10),
+		SlowCallRateThreshold(100),
+	)
+
+	slowCalls := make(chan string, 2)
+	breaker.OnSlowCall(func(e Event) { slowCalls <- e.ID })
+
+	slow := func() (interface{}, error) {
+		time.Sleep(20 * time.Millisecond)
+		return true, nil
+	}
+
+	_, err := breaker.Fire("id", slow)
+	require.NoError(t, err)
+
+	stats, err := breaker.Stats("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Closed, stats.State)
+	require.Equal(t, 1, stats.Calls)
+	require.Equal(t, 1, stats.SlowCalls)
+	require.Equal(t, 0, stats.FailedCalls)
+
+	_, err = breaker.Fire("id", slow)
+	require.NoError(t, err)
+
+	stats, err = breaker.Stats("id")
+	require.NoError(t, err)
+	require.Equal(t, schema.Open, stats.State)
+
+	require.Equal(t, "id", <-slowCalls)
+	require.Equal(t, "id", <-slowCalls)
+}